@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mintlify-previewer-backend/log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	accessModeNone  = ""
+	accessModeBasic = "basic"
+	accessModeToken = "token"
+
+	accessTokenParam  = "access_token"
+	accessCookieName  = "preview_access"
+	basicSessionTTL   = 12 * time.Hour
+	tokenPurpose      = "token"
+	sessionPurpose    = "session"
+	maxAccessTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	previewTokenSecret     []byte
+	defaultAccessTokenTTL  = getEnvDuration("PREVIEW_TOKEN_TTL", 24*time.Hour)
+	errInvalidPreviewToken = errors.New("invalid or expired access token")
+)
+
+// DeploymentAccess restricts who may view a preview.
+// Passwords are only accepted on input and are stored as bcrypt hashes.
+type DeploymentAccess struct {
+	Mode     string `json:"mode"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	TokenTTL int64  `json:"token_ttl,omitempty"` // seconds
+}
+
+// accessPolicy is the stored form of DeploymentAccess enforced by the proxy.
+type accessPolicy struct {
+	mode         string
+	username     string
+	passwordHash string
+	tokenTTL     time.Duration
+}
+
+func loadPreviewTokenSecret() []byte {
+	if secret := os.Getenv("PREVIEW_TOKEN_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+
+	log.Warn("PREVIEW_TOKEN_SECRET is not set, preview access tokens will not survive a restart")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatal("failed to generate preview token secret: ", err)
+	}
+	return secret
+}
+
+// newAccessPolicy validates the access settings of a deployment request.
+func newAccessPolicy(access *DeploymentAccess) (accessPolicy, error) {
+	if access == nil {
+		return accessPolicy{}, nil
+	}
+
+	switch access.Mode {
+	case accessModeNone:
+		return accessPolicy{}, nil
+	case accessModeBasic:
+		if access.Username == "" || access.Password == "" {
+			return accessPolicy{}, errors.New("basic access requires a username and password")
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(access.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return accessPolicy{}, fmt.Errorf("invalid password: %w", err)
+		}
+		return accessPolicy{mode: accessModeBasic, username: access.Username, passwordHash: string(hash)}, nil
+	case accessModeToken:
+		ttl := defaultAccessTokenTTL
+		if access.TokenTTL > 0 {
+			ttl = time.Duration(access.TokenTTL) * time.Second
+		}
+		if ttl > maxAccessTokenTTL {
+			return accessPolicy{}, fmt.Errorf("token_ttl must not exceed %d seconds", int64(maxAccessTokenTTL.Seconds()))
+		}
+		return accessPolicy{mode: accessModeToken, tokenTTL: ttl}, nil
+	default:
+		return accessPolicy{}, fmt.Errorf("unknown access mode %q", access.Mode)
+	}
+}
+
+// signPreviewToken returns a token granting access to a single preview until exp.
+// The purpose separates tokens handed to users from the session cookies they are exchanged for.
+func signPreviewToken(purpose, uuid string, exp time.Time) string {
+	payload := purpose + "|" + uuid + "|" + strconv.FormatInt(exp.Unix(), 10)
+	mac := hmac.New(sha256.New, previewTokenSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyPreviewToken checks a token's signature, purpose, deployment and expiry, and returns its expiry.
+func verifyPreviewToken(token, purpose, uuid string) (time.Time, error) {
+	encodedPayload, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return time.Time{}, errInvalidPreviewToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return time.Time{}, errInvalidPreviewToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return time.Time{}, errInvalidPreviewToken
+	}
+
+	mac := hmac.New(sha256.New, previewTokenSecret)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return time.Time{}, errInvalidPreviewToken
+	}
+
+	parts := strings.Split(string(payload), "|")
+	if len(parts) != 3 || parts[0] != purpose || parts[1] != uuid {
+		return time.Time{}, errInvalidPreviewToken
+	}
+	unix, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return time.Time{}, errInvalidPreviewToken
+	}
+	exp := time.Unix(unix, 0)
+	if time.Now().After(exp) {
+		return time.Time{}, errInvalidPreviewToken
+	}
+	return exp, nil
+}
+
+// authorizePreview enforces the access policy of a deployment.
+// It returns false if it has already written a response, either a challenge or a redirect.
+func authorizePreview(w http.ResponseWriter, r *http.Request, uuid string, policy accessPolicy) bool {
+	if policy.mode == accessModeNone {
+		return true
+	}
+
+	if cookie, err := r.Cookie(accessCookieName); err == nil {
+		if _, err := verifyPreviewToken(cookie.Value, sessionPurpose, uuid); err == nil {
+			return true
+		}
+	}
+
+	switch policy.mode {
+	case accessModeBasic:
+		username, password, ok := r.BasicAuth()
+		if ok && hmac.Equal([]byte(username), []byte(policy.username)) &&
+			bcrypt.CompareHashAndPassword([]byte(policy.passwordHash), []byte(password)) == nil {
+			// Avoid paying for bcrypt on every asset request of the page.
+			setPreviewSession(w, r, uuid, time.Now().Add(basicSessionTTL))
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="Preview %s", charset="UTF-8"`, uuid))
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return false
+	case accessModeToken:
+		token := r.URL.Query().Get(accessTokenParam)
+		if token == "" {
+			http.Error(w, "This preview requires an access token", http.StatusUnauthorized)
+			return false
+		}
+		exp, err := verifyPreviewToken(token, tokenPurpose, uuid)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return false
+		}
+
+		// Exchange the token for a cookie and drop it from the URL so it doesn't leak through history or referrers.
+		setPreviewSession(w, r, uuid, exp)
+		redirectURL := *r.URL
+		query := redirectURL.Query()
+		query.Del(accessTokenParam)
+		redirectURL.RawQuery = query.Encode()
+		http.Redirect(w, r, redirectURL.RequestURI(), http.StatusSeeOther)
+		return false
+	default:
+		log.FromContext(r.Context()).Errorf("Unknown access mode %q for UUID %s", policy.mode, uuid)
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return false
+	}
+}
+
+// stripPreviewCredentials removes the credentials checked by authorizePreview from a request before it
+// is proxied, so that the dev server, which runs repository code, never sees them.
+func stripPreviewCredentials(r *http.Request) {
+	r.Header.Del("Authorization")
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != accessCookieName {
+			r.AddCookie(c)
+		}
+	}
+}
+
+// setPreviewSession sets a session cookie scoped to the preview's own subdomain.
+func setPreviewSession(w http.ResponseWriter, r *http.Request, uuid string, exp time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessCookieName,
+		Value:    signPreviewToken(sessionPurpose, uuid, exp),
+		Path:     "/",
+		Expires:  exp,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
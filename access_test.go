@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyPreviewToken(t *testing.T) {
+	secret := previewTokenSecret
+	t.Cleanup(func() { previewTokenSecret = secret })
+	previewTokenSecret = []byte("test secret")
+
+	const uuid = "01jc0000000000000000000000"
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	valid := signPreviewToken(tokenPurpose, uuid, exp)
+	payload, sig, _ := strings.Cut(valid, ".")
+
+	// tamper swaps the payload of a valid token while keeping its signature.
+	tamper := func(payload string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + sig
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		purpose string
+		uuid    string
+		wantErr bool
+	}{
+		{name: "valid", token: valid, purpose: tokenPurpose, uuid: uuid},
+		{name: "expired", token: signPreviewToken(tokenPurpose, uuid, time.Now().Add(-time.Second)), purpose: tokenPurpose, uuid: uuid, wantErr: true},
+		{name: "wrong deployment", token: valid, purpose: tokenPurpose, uuid: "01jc0000000000000000000001", wantErr: true},
+		{name: "session used as a token", token: signPreviewToken(sessionPurpose, uuid, exp), purpose: tokenPurpose, uuid: uuid, wantErr: true},
+		{name: "token used as a session", token: valid, purpose: sessionPurpose, uuid: uuid, wantErr: true},
+		{name: "extended expiry", token: tamper(tokenPurpose + "|" + uuid + "|99999999999"), purpose: tokenPurpose, uuid: uuid, wantErr: true},
+		{name: "other deployment", token: tamper(tokenPurpose + "|01jc0000000000000000000001|" + strconv.FormatInt(exp.Unix(), 10)), purpose: tokenPurpose, uuid: "01jc0000000000000000000001", wantErr: true},
+		{name: "truncated signature", token: valid[:len(valid)-2], purpose: tokenPurpose, uuid: uuid, wantErr: true},
+		{name: "no signature", token: payload, purpose: tokenPurpose, uuid: uuid, wantErr: true},
+		{name: "not base64", token: "!!!." + sig, purpose: tokenPurpose, uuid: uuid, wantErr: true},
+		{name: "empty", token: "", purpose: tokenPurpose, uuid: uuid, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifyPreviewToken(tt.token, tt.purpose, tt.uuid)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidPreviewToken) {
+					t.Errorf("verifyPreviewToken() error = %v, want errInvalidPreviewToken", err)
+				}
+				return
+			}
+			if err != nil || !got.Equal(exp) {
+				t.Errorf("verifyPreviewToken() = %v, %v, want %v", got, err, exp)
+			}
+		})
+	}
+
+	// Tokens signed with another secret, as before a restart without PREVIEW_TOKEN_SECRET, are rejected.
+	previewTokenSecret = []byte("other secret")
+	if _, err := verifyPreviewToken(valid, tokenPurpose, uuid); !errors.Is(err, errInvalidPreviewToken) {
+		t.Errorf("verifyPreviewToken() with another secret error = %v, want errInvalidPreviewToken", err)
+	}
+}
+
+func TestStripPreviewCredentials(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("reviewer", "secret")
+	r.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+	r.AddCookie(&http.Cookie{Name: accessCookieName, Value: "session"})
+	r.AddCookie(&http.Cookie{Name: "lang", Value: "en"})
+
+	stripPreviewCredentials(r)
+
+	if got := r.Header.Get("Authorization"); got != "" {
+		t.Errorf("Authorization = %q, want it removed", got)
+	}
+	if _, err := r.Cookie(accessCookieName); err == nil {
+		t.Errorf("%s cookie was forwarded", accessCookieName)
+	}
+	if got, want := r.Header.Get("Cookie"), "theme=dark; lang=en"; got != want {
+		t.Errorf("Cookie = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,151 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// processStartTime returns when pid started, in clock ticks since boot.
+// Together with the PID it identifies a process across PID reuse.
+func processStartTime(pid int) (int64, error) {
+	stat, err := readProcStat(pid)
+	if err != nil {
+		return 0, err
+	}
+	// Fields after the command name start at field 3 (state); starttime is field 22.
+	return strconv.ParseInt(stat[19], 10, 64)
+}
+
+// processGroupID returns the process group of pid.
+func processGroupID(pid int) (int, error) {
+	stat, err := readProcStat(pid)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(stat[2])
+}
+
+// readProcStat returns the fields of /proc/<pid>/stat following the command name.
+func readProcStat(pid int) ([]string, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// The command name is in parentheses and may itself contain spaces or parentheses.
+	i := strings.LastIndexByte(string(b), ')')
+	if i < 0 {
+		return nil, fmt.Errorf("malformed stat for PID %d", pid)
+	}
+	fields := strings.Fields(string(b[i+1:]))
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("malformed stat for PID %d", pid)
+	}
+	return fields, nil
+}
+
+// checkAdoptable verifies that pid is still the dev server started for a deployment: the same process
+// (not a reused PID), listening on port from within its process group, and serving dir.
+func checkAdoptable(pid int, startTime int64, port int, dir string) error {
+	st, err := processStartTime(pid)
+	if err != nil {
+		return errors.New("process is gone")
+	}
+	if st != startTime {
+		return errors.New("PID has been reused by another process")
+	}
+
+	cwd, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid))
+	if err != nil {
+		return fmt.Errorf("failed to read working directory: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	if filepath.Clean(cwd) != dir {
+		return fmt.Errorf("process serves %s instead of %s", cwd, dir)
+	}
+
+	inodes, err := listeningSocketInodes(port)
+	if err != nil {
+		return err
+	}
+	if len(inodes) == 0 {
+		return fmt.Errorf("nothing listens on port %d", port)
+	}
+	if !processGroupOwnsSocket(pid, inodes) {
+		return fmt.Errorf("port %d is owned by another process", port)
+	}
+	return nil
+}
+
+// listeningSocketInodes returns the inodes of TCP sockets listening on port.
+func listeningSocketInodes(port int) (map[string]bool, error) {
+	inodes := make(map[string]bool)
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		f, err := os.Open(table)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Scan() // header
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 10 || fields[3] != "0A" { // 0A is TCP_LISTEN
+				continue
+			}
+			_, hexPort, ok := strings.Cut(fields[1], ":")
+			if !ok {
+				continue
+			}
+			if p, err := strconv.ParseInt(hexPort, 16, 32); err == nil && int(p) == port {
+				inodes[fields[9]] = true
+			}
+		}
+		_ = f.Close()
+	}
+	return inodes, nil
+}
+
+// processGroupOwnsSocket reports whether a member of the process group led by pgid holds one of the socket inodes.
+func processGroupOwnsSocket(pgid int, inodes map[string]bool) bool {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if group, err := processGroupID(pid); err != nil || group != pgid {
+			continue
+		}
+
+		fdDir := fmt.Sprintf("/proc/%d/fd", pid)
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			if inode, ok := strings.CutPrefix(target, "socket:["); ok && inodes[strings.TrimSuffix(inode, "]")] {
+				return true
+			}
+		}
+	}
+	return false
+}
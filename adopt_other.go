@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errAdoptionUnsupported = errors.New("re-adopting processes is only supported on Linux")
+
+func processStartTime(pid int) (int64, error) {
+	return 0, errAdoptionUnsupported
+}
+
+func checkAdoptable(pid int, startTime int64, port int, dir string) error {
+	return errAdoptionUnsupported
+}
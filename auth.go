@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mintlify-previewer-backend/log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+const (
+	scopeDeploy = "deploy"
+	scopeRead   = "read"
+	scopeDelete = "delete"
+	scopeAdmin  = "admin"
+	// scopeMetrics only allows scraping /metrics.
+	scopeMetrics = "metrics"
+
+	apiKeyPrefix                   = "mpk_"
+	bootstrapAdminKeyID            = "bootstrap-admin"
+	apiKeyContextKey    contextKey = "api_key"
+)
+
+type contextKey string
+
+var validScopes = []string{scopeDeploy, scopeRead, scopeDelete, scopeAdmin, scopeMetrics}
+
+// APIKey grants access to the management API.
+// The plaintext key is only returned once, when the key is created.
+type APIKey struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	Key          string     `json:"key,omitempty"`
+	Scopes       []string   `json:"scopes"`
+	Repositories []string   `json:"repositories,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
+
+// hasScope reports whether the key grants scope. The admin scope grants every scope.
+func (k *APIKey) hasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scopeAdmin) || slices.Contains(k.Scopes, scope)
+}
+
+// allowsRepository reports whether the key may act on the repository of githubURL.
+// Keys without a repository allowlist may act on any repository.
+func (k *APIKey) allowsRepository(githubURL string) bool {
+	if len(k.Repositories) == 0 {
+		return true
+	}
+	name, err := repoFullName(githubURL)
+	if err != nil {
+		return false
+	}
+	return matchRepoPatterns(k.Repositories, name)
+}
+
+// repoFullName returns the lower-cased "owner/repo" of a GitHub URL.
+func repoFullName(githubURL string) (string, error) {
+	parsedURL, err := url.Parse(githubURL)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("no repository found in URL %s", githubURL)
+	}
+	return strings.ToLower(parts[0] + "/" + strings.TrimSuffix(parts[1], ".git")), nil
+}
+
+// matchRepoPatterns reports whether name matches one of the "owner/repo" or "owner/*" patterns.
+func matchRepoPatterns(patterns []string, name string) bool {
+	owner, _, _ := strings.Cut(name, "/")
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == name || pattern == "*" || pattern == owner+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+func hashAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(b), nil
+}
+
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(validScopes, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// createAPIKey stores a new key and returns it with its plaintext value set.
+func createAPIKey(name string, scopes, repositories []string) (*APIKey, error) {
+	if err := validateScopes(scopes); err != nil {
+		return nil, err
+	}
+	plaintext, err := generateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	k := &APIKey{
+		ID:           strings.ToLower(ulid.Make().String()),
+		Name:         name,
+		Key:          plaintext,
+		Scopes:       scopes,
+		Repositories: repositories,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err := store.CreateAPIKey(k, hashAPIKey(plaintext)); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// findAPIKey looks up an active key by its plaintext value.
+func findAPIKey(plaintext string) (*APIKey, error) {
+	return store.FindAPIKey(hashAPIKey(plaintext))
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// bootstrapAdminKey registers ADMIN_API_KEY as an admin key, replacing a previously bootstrapped value.
+// Once ADMIN_API_KEY is unset, the previously bootstrapped key is revoked.
+func bootstrapAdminKey() {
+	plaintext := os.Getenv("ADMIN_API_KEY")
+	if plaintext != "" {
+		k := &APIKey{ID: bootstrapAdminKeyID, Name: "bootstrap admin", Scopes: []string{scopeAdmin}, CreatedAt: time.Now().UTC()}
+		if err := store.PutAPIKey(k, hashAPIKey(plaintext)); err != nil {
+			log.Fatal("failed to register bootstrap admin key: ", err)
+		}
+		log.Info("Bootstrap admin API key registered")
+		return
+	}
+
+	revoked, err := store.RevokeAPIKey(bootstrapAdminKeyID)
+	if err != nil {
+		log.Fatal("failed to revoke bootstrap admin key: ", err)
+	}
+	if revoked {
+		log.Info("ADMIN_API_KEY is not set, revoked the bootstrap admin API key")
+	}
+
+	count, err := store.CountAPIKeys()
+	if err != nil {
+		log.Fatal("failed to count API keys: ", err)
+	}
+	if count == 0 {
+		log.Warn("No API keys exist and ADMIN_API_KEY is not set, the management API is unusable")
+	}
+}
+
+// requireScope is a middleware that rejects requests without an active API key granting scope.
+// Keys are read from "Authorization: Bearer <key>" or the X-API-Key header.
+func requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			plaintext := r.Header.Get("X-API-Key")
+			if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				plaintext = strings.TrimSpace(bearer)
+			}
+			if plaintext == "" {
+				http.Error(w, "Missing API key", http.StatusUnauthorized)
+				return
+			}
+
+			k, err := findAPIKey(plaintext)
+			if err != nil {
+				if errors.Is(err, errNotFound) {
+					http.Error(w, "Invalid API key", http.StatusUnauthorized)
+					return
+				}
+				log.FromContext(r.Context()).Error("Failed to look up API key: ", err)
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			if !k.hasScope(scope) {
+				http.Error(w, fmt.Sprintf("API key lacks the %s scope", scope), http.StatusForbidden)
+				return
+			}
+
+			if err := store.TouchAPIKey(k.ID); err != nil {
+				log.FromContext(r.Context()).Error("Failed to update API key usage: ", err)
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, k)))
+		})
+	}
+}
+
+// apiKeyFromContext returns the API key that authenticated the request.
+func apiKeyFromContext(ctx context.Context) *APIKey {
+	k, _ := ctx.Value(apiKeyContextKey).(*APIKey)
+	return k
+}
+
+// authorizeRepository rejects the request if its API key may not act on githubURL.
+func authorizeRepository(w http.ResponseWriter, r *http.Request, githubURL string) bool {
+	k := apiKeyFromContext(r.Context())
+	if k == nil || k.allowsRepository(githubURL) {
+		return true
+	}
+	http.Error(w, "API key is not allowed to access this repository", http.StatusForbidden)
+	return false
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		scope  string
+		want   bool
+	}{
+		{name: "granted", scopes: []string{scopeRead, scopeDeploy}, scope: scopeDeploy, want: true},
+		{name: "not granted", scopes: []string{scopeRead}, scope: scopeDelete},
+		{name: "admin grants everything", scopes: []string{scopeAdmin}, scope: scopeMetrics, want: true},
+		{name: "metrics grants nothing else", scopes: []string{scopeMetrics}, scope: scopeRead},
+		{name: "no scopes", scope: scopeRead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &APIKey{Scopes: tt.scopes}
+			if got := k.hasScope(tt.scope); got != tt.want {
+				t.Errorf("hasScope(%q) = %v, want %v", tt.scope, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowsRepository(t *testing.T) {
+	tests := []struct {
+		name         string
+		repositories []string
+		githubURL    string
+		want         bool
+	}{
+		{name: "no allowlist", githubURL: "https://github.com/acme/docs/pull/1", want: true},
+		{name: "exact repository", repositories: []string{"acme/docs"}, githubURL: "https://github.com/acme/docs/pull/1", want: true},
+		{name: "case insensitive", repositories: []string{" Acme/Docs "}, githubURL: "https://github.com/ACME/docs.git", want: true},
+		{name: "owner wildcard", repositories: []string{"acme/*"}, githubURL: "https://github.com/acme/website/pull/2", want: true},
+		{name: "any repository", repositories: []string{"*"}, githubURL: "https://github.com/other/docs/pull/1", want: true},
+		{name: "other repository", repositories: []string{"acme/docs"}, githubURL: "https://github.com/acme/website/pull/1"},
+		{name: "other owner", repositories: []string{"acme/*"}, githubURL: "https://github.com/acme-evil/docs/pull/1"},
+		{name: "wildcard is not a prefix match", repositories: []string{"acme/doc*"}, githubURL: "https://github.com/acme/docs/pull/1"},
+		{name: "no repository in URL", repositories: []string{"acme/docs"}, githubURL: "https://github.com/acme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &APIKey{Repositories: tt.repositories}
+			if got := k.allowsRepository(tt.githubURL); got != tt.want {
+				t.Errorf("allowsRepository(%q) = %v, want %v", tt.githubURL, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchRepoPatterns(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		name     string
+		want     bool
+	}{
+		{patterns: []string{"acme/docs"}, name: "acme/docs", want: true},
+		{patterns: []string{"other/docs", "acme/*"}, name: "acme/docs", want: true},
+		{patterns: []string{"*"}, name: "acme/docs", want: true},
+		{patterns: []string{"acme/docs"}, name: "acme/docs-v2"},
+		{patterns: []string{"*/docs"}, name: "acme/docs"},
+		{name: "acme/docs"},
+	}
+	for _, tt := range tests {
+		if got := matchRepoPatterns(tt.patterns, tt.name); got != tt.want {
+			t.Errorf("matchRepoPatterns(%q, %q) = %v, want %v", tt.patterns, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	useTestStore(t)
+	deployKey, err := createAPIKey("ci", []string{scopeDeploy}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminKey, err := createAPIKey("ops", []string{scopeAdmin}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revokedKey, err := createAPIKey("old", []string{scopeDeploy}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.RevokeAPIKey(revokedKey.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		want    int
+		wantKey string
+	}{
+		{name: "bearer token", header: "Authorization", value: "Bearer " + deployKey.Key, want: http.StatusOK, wantKey: deployKey.ID},
+		{name: "X-API-Key header", header: "X-API-Key", value: deployKey.Key, want: http.StatusOK, wantKey: deployKey.ID},
+		{name: "admin key", header: "Authorization", value: "Bearer " + adminKey.Key, want: http.StatusOK, wantKey: adminKey.ID},
+		{name: "missing key", want: http.StatusUnauthorized},
+		{name: "unknown key", header: "Authorization", value: "Bearer mpk_unknown", want: http.StatusUnauthorized},
+		{name: "revoked key", header: "X-API-Key", value: revokedKey.Key, want: http.StatusUnauthorized},
+		{name: "other scheme", header: "Authorization", value: "Basic " + deployKey.Key, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotKey string
+			handler := requireScope(scopeDeploy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotKey = apiKeyFromContext(r.Context()).ID
+			}))
+			r := httptest.NewRequest(http.MethodPost, "/deploy", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want || gotKey != tt.wantKey {
+				t.Errorf("got status %d with key %q, want %d with key %q", w.Code, gotKey, tt.want, tt.wantKey)
+			}
+		})
+	}
+
+	t.Run("missing scope", func(t *testing.T) {
+		handler := requireScope(scopeDelete)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("handler called without the delete scope")
+		}))
+		r := httptest.NewRequest(http.MethodDelete, "/deployments/1", nil)
+		r.Header.Set("X-API-Key", deployKey.Key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("got status %d, want %d", w.Code, http.StatusForbidden)
+		}
+	})
+
+	keys, err := store.ListAPIKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		if k.ID == deployKey.ID && k.LastUsedAt == nil {
+			t.Error("requireScope() did not record the use of the key")
+		}
+	}
+}
+
+func TestBootstrapAdminKey(t *testing.T) {
+	useTestStore(t)
+
+	t.Setenv("ADMIN_API_KEY", "mpk_bootstrap")
+	bootstrapAdminKey()
+	k, err := findAPIKey("mpk_bootstrap")
+	if err != nil || k.ID != bootstrapAdminKeyID || !k.hasScope(scopeAdmin) {
+		t.Fatalf("findAPIKey() = %+v, %v, want the bootstrap admin key", k, err)
+	}
+
+	// Rotating the key replaces the old value.
+	t.Setenv("ADMIN_API_KEY", "mpk_rotated")
+	bootstrapAdminKey()
+	if _, err := findAPIKey("mpk_bootstrap"); !errors.Is(err, errNotFound) {
+		t.Errorf("findAPIKey() with the rotated key error = %v, want errNotFound", err)
+	}
+	if _, err := findAPIKey("mpk_rotated"); err != nil {
+		t.Errorf("findAPIKey() with the new key error = %v", err)
+	}
+
+	// Unsetting it revokes the bootstrapped key.
+	t.Setenv("ADMIN_API_KEY", "")
+	bootstrapAdminKey()
+	if _, err := findAPIKey("mpk_rotated"); !errors.Is(err, errNotFound) {
+		t.Errorf("findAPIKey() after unsetting ADMIN_API_KEY error = %v, want errNotFound", err)
+	}
+	bootstrapAdminKey()
+
+	// Setting it again reinstates the key.
+	t.Setenv("ADMIN_API_KEY", "mpk_bootstrap")
+	bootstrapAdminKey()
+	if _, err := findAPIKey("mpk_bootstrap"); err != nil {
+		t.Errorf("findAPIKey() after setting ADMIN_API_KEY again error = %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// builderAuto picks the builder of a deployment from its checkout, once it has been cloned.
+const builderAuto = "auto"
+
+// Builder turns the checkout of a docs site into a preview, either a live-reloading dev server
+// or a static build served by the previewer.
+type Builder interface {
+	// Name identifies the builder in the API and the database.
+	Name() string
+	// ValidateConfig checks the path of the site's config requested for a deployment, relative to the
+	// repository, and returns it cleaned. An empty path selects the builder's default, if it has one.
+	ValidateConfig(path string) (string, error)
+	// ValidateSettings checks the builder's own settings of a deployment, such as a pinned toolchain
+	// version, and that the builder supports its mode.
+	ValidateSettings(dep *Deployment) error
+	// Detect reports whether a checkout holds a site for this builder, and the path of its config.
+	Detect(checkout string) (string, bool)
+	// Install makes the toolchain a deployment is built with available and returns the path of the CLI to run.
+	Install(ctx context.Context, dep *Deployment) (string, error)
+	// SupportsDev reports whether the builder can run a dev server, rather than only static builds.
+	SupportsDev() bool
+	// DevCommand returns the command running a dev server for config on port, from the config's
+	// directory. It is only called on builders that support dev mode.
+	DevCommand(cli, config string, port int) []string
+	// BuildCommand returns the command building config into a static site, run from the config's
+	// directory, and the directory relative to it the output lands in. A nil command serves that
+	// directory as it is.
+	BuildCommand(cli, config string) ([]string, string)
+}
+
+// builders are the available builders, in the order auto detection tries them.
+var builders = []Builder{
+	mintlifyBuilder{},
+	mkdocsBuilder{},
+	staticDirBuilder{},
+}
+
+var (
+	errUnknownBuilder = fmt.Errorf("builder must be %s or %s", strings.Join(builderNames(), ", "), builderAuto)
+	errNoBuilder      = errors.New("no supported docs site found in the repository")
+)
+
+func builderNames() []string {
+	var names []string
+	for _, b := range builders {
+		names = append(names, b.Name())
+	}
+	return names
+}
+
+// lookupBuilder returns the builder called name, Mintlify if it is empty.
+func lookupBuilder(name string) (Builder, error) {
+	if name == "" {
+		name = builderMintlify
+	}
+	for _, b := range builders {
+		if b.Name() == name {
+			return b, nil
+		}
+	}
+	return nil, errUnknownBuilder
+}
+
+// validateBuilderRequest checks the builder settings of a new deployment and fills in their defaults.
+// Deployments using auto are checked again once their builder has been detected.
+func validateBuilderRequest(dep *Deployment) error {
+	if dep.Builder == builderAuto {
+		if dep.DocsPath != "" {
+			path := filepath.Clean(dep.DocsPath)
+			if !filepath.IsLocal(path) {
+				return errors.New("docs_path must be a relative path inside the repository")
+			}
+			dep.DocsPath = path
+		}
+		return nil
+	}
+
+	b, err := lookupBuilder(dep.Builder)
+	if err != nil {
+		return err
+	}
+	dep.Builder = b.Name()
+	if dep.DocsPath, err = b.ValidateConfig(dep.DocsPath); err != nil {
+		return err
+	}
+	return checkBuilder(dep, b)
+}
+
+// checkBuilder checks that the other settings of a deployment apply to its builder.
+func checkBuilder(dep *Deployment, b Builder) error {
+	if dep.Mode != deploymentModeStatic && !b.SupportsDev() {
+		return fmt.Errorf("the %s builder only supports %s mode", b.Name(), deploymentModeStatic)
+	}
+	return b.ValidateSettings(dep)
+}
+
+// rejectMintlifyVersion is the settings check of builders whose toolchain isn't pinned per deployment.
+func rejectMintlifyVersion(b Builder, dep *Deployment) error {
+	if dep.MintlifyVersion != "" {
+		return fmt.Errorf("mintlify_version doesn't apply to the %s builder", b.Name())
+	}
+	return nil
+}
+
+// resolveBuilder returns the builder of a cloned deployment and the path of its config,
+// detecting both for deployments using auto.
+func resolveBuilder(dep *Deployment, checkout string) (Builder, string, error) {
+	if dep.Builder != builderAuto {
+		b, err := lookupBuilder(dep.Builder)
+		return b, dep.DocsPath, err
+	}
+	for _, b := range builders {
+		// An explicit docs_path selects the builder whose kind of config it names,
+		// otherwise the root of the checkout is searched for a config.
+		var config string
+		var ok bool
+		if dep.DocsPath != "" {
+			var err error
+			config, err = b.ValidateConfig(dep.DocsPath)
+			ok = err == nil
+		} else {
+			config, ok = b.Detect(checkout)
+		}
+		if !ok {
+			continue
+		}
+		if err := checkBuilder(dep, b); err != nil {
+			return nil, "", fmt.Errorf("detected a %s site: %w", b.Name(), err)
+		}
+		return b, config, nil
+	}
+	return nil, "", errNoBuilder
+}
+
+// detectConfig returns the first of the given config files found at the root of a checkout.
+func detectConfig(checkout string, names ...string) (string, bool) {
+	for _, name := range names {
+		if info, err := os.Stat(filepath.Join(checkout, name)); err == nil && info.Mode().IsRegular() {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// validateConfigPath checks that the path of a config is local to the repository and has one of
+// the given extensions, and returns it cleaned.
+func validateConfigPath(path string, exts ...string) (string, error) {
+	if !slices.Contains(exts, filepath.Ext(path)) {
+		return "", fmt.Errorf("docs_path must end with %s", strings.Join(exts, " or "))
+	}
+	path = filepath.Clean(path)
+	if !filepath.IsLocal(path) {
+		return "", errors.New("docs_path must be a relative path inside the repository")
+	}
+	return path, nil
+}
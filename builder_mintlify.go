@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+const builderMintlify = "mintlify"
+
+var (
+	// The command producing a static export differs between CLI versions and not all of them have
+	// one, so static mode is only offered for Mintlify sites once MINTLIFY_BUILD_ARGS is set, to the
+	// arguments passed to the CLI, comma-separated. MINTLIFY_BUILD_OUTPUT is where the export lands,
+	// relative to the docs directory.
+	staticBuildArgs   = getEnvList("MINTLIFY_BUILD_ARGS", nil)
+	staticBuildOutput = getEnv("MINTLIFY_BUILD_OUTPUT", "out")
+)
+
+// mintlifyBuilder previews Mintlify sites, with the CLI version pinned by the deployment.
+type mintlifyBuilder struct{}
+
+func (mintlifyBuilder) Name() string {
+	return builderMintlify
+}
+
+// ValidateConfig requires the path of the site's docs.json or mint.json.
+func (mintlifyBuilder) ValidateConfig(path string) (string, error) {
+	if path == "" {
+		return "", errors.New("docs_path cannot be empty")
+	}
+	return validateConfigPath(path, ".json")
+}
+
+// ValidateSettings checks the Mintlify version the deployment pins, if any, and that static
+// builds are configured when the deployment asks for one.
+func (mintlifyBuilder) ValidateSettings(dep *Deployment) error {
+	if dep.Mode == deploymentModeStatic && len(staticBuildArgs) == 0 {
+		return errors.New("static mode is not available for Mintlify sites on this server")
+	}
+	return validateMintlifyVersion(dep.MintlifyVersion)
+}
+
+func (mintlifyBuilder) Detect(checkout string) (string, bool) {
+	return detectConfig(checkout, "docs.json", "mint.json")
+}
+
+// Install installs the Mintlify version the deployment pins, or the default one.
+func (mintlifyBuilder) Install(ctx context.Context, dep *Deployment) (string, error) {
+	return mintlifyToolchain.ensure(ctx, dep.MintlifyVersion)
+}
+
+func (mintlifyBuilder) SupportsDev() bool {
+	return true
+}
+
+func (mintlifyBuilder) DevCommand(cli, _ string, port int) []string {
+	return []string{cli, "dev", "--no-open", "--port", strconv.Itoa(port)}
+}
+
+func (mintlifyBuilder) BuildCommand(cli, _ string) ([]string, string) {
+	return append([]string{cli}, staticBuildArgs...), staticBuildOutput
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+)
+
+const builderMkDocs = "mkdocs"
+
+// mkdocsBuilder previews MkDocs sites with the mkdocs found in PATH, along with whatever
+// themes and plugins are installed next to it.
+type mkdocsBuilder struct{}
+
+func (mkdocsBuilder) Name() string {
+	return builderMkDocs
+}
+
+// ValidateConfig requires the path of the site's mkdocs.yml, at the root of the repository by default.
+func (mkdocsBuilder) ValidateConfig(path string) (string, error) {
+	if path == "" {
+		return "mkdocs.yml", nil
+	}
+	return validateConfigPath(path, ".yml", ".yaml")
+}
+
+func (b mkdocsBuilder) ValidateSettings(dep *Deployment) error {
+	return rejectMintlifyVersion(b, dep)
+}
+
+func (mkdocsBuilder) Detect(checkout string) (string, bool) {
+	return detectConfig(checkout, "mkdocs.yml", "mkdocs.yaml")
+}
+
+// Install only looks MkDocs up, it isn't managed by the previewer.
+func (mkdocsBuilder) Install(context.Context, *Deployment) (string, error) {
+	path, err := exec.LookPath("mkdocs")
+	if err != nil {
+		return "", fmt.Errorf("MkDocs is not installed: %w", err)
+	}
+	return path, nil
+}
+
+func (mkdocsBuilder) SupportsDev() bool {
+	return true
+}
+
+func (mkdocsBuilder) DevCommand(cli, config string, port int) []string {
+	return []string{cli, "serve", "--config-file", filepath.Base(config), "--dev-addr", fmt.Sprintf("127.0.0.1:%d", port)}
+}
+
+func (mkdocsBuilder) BuildCommand(cli, config string) ([]string, string) {
+	return []string{cli, "build", "--config-file", filepath.Base(config), "--site-dir", "site"}, "site"
+}
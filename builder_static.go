@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+)
+
+const builderStatic = "static"
+
+// staticDirBuilder previews a directory of prebuilt HTML as it is, for sites generated by tools
+// the previewer doesn't know about. It only supports static mode.
+type staticDirBuilder struct{}
+
+func (staticDirBuilder) Name() string {
+	return builderStatic
+}
+
+// ValidateConfig requires the path of the site's index.html, at the root of the repository by default.
+func (staticDirBuilder) ValidateConfig(path string) (string, error) {
+	if path == "" {
+		return "index.html", nil
+	}
+	return validateConfigPath(path, ".html")
+}
+
+func (b staticDirBuilder) ValidateSettings(dep *Deployment) error {
+	return rejectMintlifyVersion(b, dep)
+}
+
+func (staticDirBuilder) Detect(checkout string) (string, bool) {
+	return detectConfig(checkout, "index.html")
+}
+
+func (staticDirBuilder) Install(context.Context, *Deployment) (string, error) {
+	return "", nil
+}
+
+func (staticDirBuilder) SupportsDev() bool {
+	return false
+}
+
+func (staticDirBuilder) DevCommand(string, string, int) []string {
+	return nil
+}
+
+func (staticDirBuilder) BuildCommand(string, string) ([]string, string) {
+	return nil, "."
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateBuilderRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		dep         Deployment
+		wantErr     bool
+		wantBuilder string
+		wantPath    string
+	}{
+		{name: "mintlify by default", dep: Deployment{DocsPath: "docs/./mint.json", Mode: deploymentModeDev}, wantBuilder: builderMintlify, wantPath: "docs/mint.json"},
+		{name: "mintlify needs a config", dep: Deployment{Mode: deploymentModeDev}, wantErr: true},
+		{name: "mintlify config is json", dep: Deployment{DocsPath: "mkdocs.yml", Mode: deploymentModeDev}, wantErr: true},
+		{name: "config outside the repository", dep: Deployment{DocsPath: "../mint.json", Mode: deploymentModeDev}, wantErr: true},
+		{name: "pinned mintlify version", dep: Deployment{DocsPath: "mint.json", MintlifyVersion: "4.0.500", Mode: deploymentModeDev}, wantBuilder: builderMintlify, wantPath: "mint.json"},
+		{name: "invalid mintlify version", dep: Deployment{DocsPath: "mint.json", MintlifyVersion: "4; rm", Mode: deploymentModeDev}, wantErr: true},
+		{name: "mintlify static mode needs build args", dep: Deployment{DocsPath: "mint.json", Mode: deploymentModeStatic}, wantErr: true},
+		{name: "mkdocs default config", dep: Deployment{Builder: builderMkDocs, Mode: deploymentModeDev}, wantBuilder: builderMkDocs, wantPath: "mkdocs.yml"},
+		{name: "mkdocs has no mintlify version", dep: Deployment{Builder: builderMkDocs, MintlifyVersion: "4.0.500", Mode: deploymentModeDev}, wantErr: true},
+		{name: "static directory in static mode", dep: Deployment{Builder: builderStatic, Mode: deploymentModeStatic}, wantBuilder: builderStatic, wantPath: "index.html"},
+		{name: "static directory has no dev server", dep: Deployment{Builder: builderStatic, Mode: deploymentModeDev}, wantErr: true},
+		{name: "unknown builder", dep: Deployment{Builder: "hugo", Mode: deploymentModeDev}, wantErr: true},
+		{name: "auto keeps the builder open", dep: Deployment{Builder: builderAuto, Mode: deploymentModeDev}, wantBuilder: builderAuto},
+		{name: "auto rejects paths outside the repository", dep: Deployment{Builder: builderAuto, DocsPath: "/etc/mkdocs.yml", Mode: deploymentModeDev}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dep := tt.dep
+			err := validateBuilderRequest(&dep)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateBuilderRequest() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if dep.Builder != tt.wantBuilder || dep.DocsPath != tt.wantPath {
+				t.Errorf("got builder %q and docs_path %q, want %q and %q", dep.Builder, dep.DocsPath, tt.wantBuilder, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestMintlifyStaticMode(t *testing.T) {
+	args := staticBuildArgs
+	t.Cleanup(func() { staticBuildArgs = args })
+	staticBuildArgs = []string{"export"}
+
+	dep := Deployment{DocsPath: "docs.json", Mode: deploymentModeStatic}
+	if err := validateBuilderRequest(&dep); err != nil {
+		t.Fatalf("validateBuilderRequest() error = %v with MINTLIFY_BUILD_ARGS set", err)
+	}
+	command, output := mintlifyBuilder{}.BuildCommand("mint", dep.DocsPath)
+	if len(command) != 2 || command[1] != "export" || output != staticBuildOutput {
+		t.Errorf("BuildCommand() = %v, %q", command, output)
+	}
+}
+
+func TestResolveBuilderDetects(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      []string
+		dep        Deployment
+		wantErr    bool
+		wantName   string
+		wantConfig string
+	}{
+		{name: "mintlify", files: []string{"docs.json", "index.html"}, dep: Deployment{Mode: deploymentModeDev}, wantName: builderMintlify, wantConfig: "docs.json"},
+		{name: "mkdocs", files: []string{"mkdocs.yml"}, dep: Deployment{Mode: deploymentModeDev}, wantName: builderMkDocs, wantConfig: "mkdocs.yml"},
+		{name: "static directory", files: []string{"index.html"}, dep: Deployment{Mode: deploymentModeStatic}, wantName: builderStatic, wantConfig: "index.html"},
+		{name: "static directory in dev mode", files: []string{"index.html"}, dep: Deployment{Mode: deploymentModeDev}, wantErr: true},
+		{name: "explicit docs_path", files: []string{"docs.json"}, dep: Deployment{DocsPath: "site/mkdocs.yaml", Mode: deploymentModeDev}, wantName: builderMkDocs, wantConfig: "site/mkdocs.yaml"},
+		{name: "nothing to build", files: []string{"README.md"}, dep: Deployment{Mode: deploymentModeDev}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkout := t.TempDir()
+			for _, name := range tt.files {
+				if err := os.WriteFile(filepath.Join(checkout, name), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			dep := tt.dep
+			dep.Builder = builderAuto
+
+			b, config, err := resolveBuilder(&dep, checkout)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveBuilder() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if b.Name() != tt.wantName || config != tt.wantConfig {
+				t.Errorf("got %s with %q, want %s with %q", b.Name(), config, tt.wantName, tt.wantConfig)
+			}
+		})
+	}
+}
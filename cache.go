@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// previewAssets caches immutable static assets served by previews.
+// It is disabled unless PROXY_CACHE_MAX_BYTES is set.
+var previewAssets = newAssetCache(
+	getEnvInt64("PROXY_CACHE_MAX_BYTES", 0),
+	getEnvInt64("PROXY_CACHE_MAX_ENTRY_BYTES", 1<<20),
+)
+
+type cachedAsset struct {
+	key    string
+	uuid   string
+	status int
+	header http.Header
+	body   []byte
+}
+
+// assetCache is a size-bounded LRU cache of proxied responses.
+type assetCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	maxEntry int64
+	size     int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+func newAssetCache(maxBytes, maxEntry int64) *assetCache {
+	return &assetCache{
+		maxBytes: maxBytes,
+		maxEntry: maxEntry,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *assetCache) enabled() bool {
+	return c.maxBytes > 0
+}
+
+// cacheKey returns the key for a request, or "" if the request must not be served from the cache.
+func (c *assetCache) cacheKey(uuid string, r *http.Request) string {
+	if !c.enabled() || r.Method != http.MethodGet {
+		return ""
+	}
+	if r.Header.Get("Range") != "" || r.Header.Get("Authorization") != "" {
+		return ""
+	}
+	return uuid + "|" + r.Header.Get("Accept-Encoding") + "|" + r.URL.RequestURI()
+}
+
+func (c *assetCache) get(key string) (*cachedAsset, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*cachedAsset), true
+}
+
+func (c *assetCache) add(asset *cachedAsset) {
+	size := int64(len(asset.body))
+	if size > c.maxEntry || size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[asset.key]; ok {
+		c.removeElement(el)
+	}
+	c.items[asset.key] = c.ll.PushFront(asset)
+	c.size += size
+
+	for c.size > c.maxBytes {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// purge removes every cached asset belonging to a deployment.
+func (c *assetCache) purge(uuid string) {
+	if !c.enabled() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*cachedAsset).uuid == uuid {
+			c.removeElement(el)
+		}
+		el = next
+	}
+}
+
+func (c *assetCache) removeElement(el *list.Element) {
+	asset := c.ll.Remove(el).(*cachedAsset)
+	delete(c.items, asset.key)
+	c.size -= int64(len(asset.body))
+}
+
+// serve writes a cached asset to w.
+func (asset *cachedAsset) serve(w http.ResponseWriter) {
+	for k, v := range asset.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Preview-Cache", "HIT")
+	w.WriteHeader(asset.status)
+	_, _ = w.Write(asset.body)
+}
+
+// isImmutableResponse reports whether an upstream response may be stored in the asset cache.
+// Dev servers mark content-hashed assets as immutable; everything else is left to the upstream.
+func isImmutableResponse(status int, header http.Header) bool {
+	if status != http.StatusOK || header.Get("Set-Cookie") != "" {
+		return false
+	}
+	if vary := header.Get("Vary"); vary != "" && !strings.EqualFold(vary, "Accept-Encoding") {
+		return false
+	}
+	return strings.Contains(strings.ToLower(header.Get("Cache-Control")), "immutable")
+}
+
+// recordingWriter passes a response through while keeping a copy of it for the asset cache.
+// Whether the response qualifies is decided once its header is written, and only then is it buffered.
+type recordingWriter struct {
+	http.ResponseWriter
+	status    int
+	cacheable bool
+	body      bytes.Buffer
+	maxBytes  int64
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	// Informational responses are followed by the real one.
+	if rw.status == 0 && status >= http.StatusOK {
+		rw.status = status
+		rw.cacheable = isImmutableResponse(status, rw.Header()) && rw.fits(rw.Header().Get("Content-Length"))
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+// fits reports whether a response with the given Content-Length may fit in the cache.
+func (rw *recordingWriter) fits(contentLength string) bool {
+	if contentLength == "" {
+		return true
+	}
+	n, err := strconv.ParseInt(contentLength, 10, 64)
+	return err == nil && n <= rw.maxBytes
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.cacheable {
+		if int64(rw.body.Len()+len(p)) > rw.maxBytes {
+			rw.cacheable = false
+			rw.body = bytes.Buffer{}
+		} else {
+			rw.body.Write(p)
+		}
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush streamed responses.
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// asset returns the recorded response if it is eligible for caching.
+func (rw *recordingWriter) asset(key, uuid string) (*cachedAsset, bool) {
+	if !rw.cacheable {
+		return nil, false
+	}
+	return &cachedAsset{
+		key:    key,
+		uuid:   uuid,
+		status: rw.status,
+		header: rw.Header().Clone(),
+		body:   bytes.Clone(rw.body.Bytes()),
+	}, true
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// cachedKeys returns the keys of a cache from most to least recently used.
+func cachedKeys(c *assetCache) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var keys []string
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		keys = append(keys, el.Value.(*cachedAsset).key)
+	}
+	return keys
+}
+
+func TestAssetCacheEviction(t *testing.T) {
+	c := newAssetCache(30, 10)
+	asset := func(key string, size int) *cachedAsset {
+		return &cachedAsset{key: key, uuid: "a", status: http.StatusOK, body: make([]byte, size)}
+	}
+
+	c.add(asset("one", 10))
+	c.add(asset("two", 10))
+	c.add(asset("three", 10))
+	if _, ok := c.get("one"); !ok {
+		t.Fatal("get(one) missed before the cache was full")
+	}
+	// "two" is now the least recently used and makes room for "four".
+	c.add(asset("four", 10))
+	if got, want := cachedKeys(c), []string{"four", "one", "three"}; !slices.Equal(got, want) {
+		t.Errorf("cached keys = %v, want %v", got, want)
+	}
+	if _, ok := c.get("two"); ok {
+		t.Error("get(two) hit after it was evicted")
+	}
+
+	// Entries above the per-entry limit are never stored, nor do they evict anything.
+	c.add(asset("big", 11))
+	if got, want := cachedKeys(c), []string{"four", "one", "three"}; !slices.Equal(got, want) {
+		t.Errorf("cached keys after adding an oversized entry = %v, want %v", got, want)
+	}
+
+	// Replacing an entry accounts for its new size.
+	c.add(asset("one", 5))
+	c.add(asset("five", 5))
+	if got, want := cachedKeys(c), []string{"five", "one", "four", "three"}; !slices.Equal(got, want) {
+		t.Errorf("cached keys after replacing an entry = %v, want %v", got, want)
+	}
+	if c.size != 30 {
+		t.Errorf("size = %d, want 30", c.size)
+	}
+}
+
+func TestAssetCachePurge(t *testing.T) {
+	c := newAssetCache(1<<10, 1<<10)
+	for _, key := range []string{"a|1", "b|1", "a|2", "b|2"} {
+		uuid, _, _ := strings.Cut(key, "|")
+		c.add(&cachedAsset{key: key, uuid: uuid, status: http.StatusOK, body: []byte(key)})
+	}
+
+	c.purge("a")
+	if got, want := cachedKeys(c), []string{"b|2", "b|1"}; !slices.Equal(got, want) {
+		t.Errorf("cached keys after purging a = %v, want %v", got, want)
+	}
+	if c.size != 6 {
+		t.Errorf("size = %d, want 6", c.size)
+	}
+	c.purge("c")
+	if got := cachedKeys(c); len(got) != 2 {
+		t.Errorf("cached keys after purging an uncached deployment = %v", got)
+	}
+}
+
+func TestAssetCacheKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		want   bool
+	}{
+		{name: "get", method: http.MethodGet, want: true},
+		{name: "head", method: http.MethodHead},
+		{name: "post", method: http.MethodPost},
+		{name: "range", method: http.MethodGet, header: http.Header{"Range": {"bytes=0-1"}}},
+		{name: "authorization", method: http.MethodGet, header: http.Header{"Authorization": {"Basic x"}}},
+	}
+	c := newAssetCache(1<<10, 1<<10)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/_next/static/app.js", nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			if got := c.cacheKey("a", r); (got != "") != tt.want {
+				t.Errorf("cacheKey() = %q, want a key %v", got, tt.want)
+			}
+		})
+	}
+
+	if key := newAssetCache(0, 0).cacheKey("a", httptest.NewRequest(http.MethodGet, "/", nil)); key != "" {
+		t.Errorf("cacheKey() of a disabled cache = %q", key)
+	}
+}
+
+func TestRecordingWriter(t *testing.T) {
+	const body = "console.log(1)"
+	tests := []struct {
+		name   string
+		status int
+		header http.Header
+		writes int
+		want   bool
+	}{
+		{name: "immutable", status: http.StatusOK, header: http.Header{"Cache-Control": {"public, max-age=31536000, immutable"}}, writes: 1, want: true},
+		{name: "implicit status", header: http.Header{"Cache-Control": {"immutable"}}, writes: 2, want: true},
+		{name: "not immutable", status: http.StatusOK, header: http.Header{"Cache-Control": {"no-cache"}}, writes: 1},
+		{name: "not found", status: http.StatusNotFound, header: http.Header{"Cache-Control": {"immutable"}}, writes: 1},
+		{name: "sets a cookie", status: http.StatusOK, header: http.Header{"Cache-Control": {"immutable"}, "Set-Cookie": {"a=b"}}, writes: 1},
+		{name: "varies", status: http.StatusOK, header: http.Header{"Cache-Control": {"immutable"}, "Vary": {"Cookie"}}, writes: 1},
+		{name: "declared too large", status: http.StatusOK, header: http.Header{"Cache-Control": {"immutable"}, "Content-Length": {"4096"}}, writes: 1},
+		{name: "grows too large", status: http.StatusOK, header: http.Header{"Cache-Control": {"immutable"}}, writes: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rw := &recordingWriter{ResponseWriter: w, maxBytes: 2 * int64(len(body))}
+			for k, v := range tt.header {
+				rw.Header()[k] = v
+			}
+			if tt.status != 0 {
+				rw.WriteHeader(tt.status)
+			}
+			for i := 0; i < tt.writes; i++ {
+				_, _ = rw.Write([]byte(body))
+			}
+			if w.Body.Len() != tt.writes*len(body) {
+				t.Errorf("passed %d bytes through, want %d", w.Body.Len(), tt.writes*len(body))
+			}
+			if !tt.want && rw.body.Len() != 0 {
+				t.Errorf("recordingWriter kept %d bytes of a response that does not qualify", rw.body.Len())
+			}
+
+			asset, ok := rw.asset("key", "a")
+			if ok != tt.want {
+				t.Fatalf("asset() ok = %v, want %v", ok, tt.want)
+			}
+			if ok && (string(asset.body) != strings.Repeat(body, tt.writes) || asset.status != http.StatusOK) {
+				t.Errorf("asset() = %d %q", asset.status, asset.body)
+			}
+		})
+	}
+
+	// An informational response does not decide the outcome.
+	w := httptest.NewRecorder()
+	rw := &recordingWriter{ResponseWriter: w, maxBytes: 1 << 10}
+	rw.WriteHeader(http.StatusEarlyHints)
+	rw.Header().Set("Cache-Control", "immutable")
+	rw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte(body))
+	if _, ok := rw.asset("key", "a"); !ok {
+		t.Error("asset() after early hints is not cacheable")
+	}
+}
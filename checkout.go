@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mintlify-previewer-backend/log"
+	"os"
+	"path/filepath"
+)
+
+// prepareDocsDir makes a fresh checkout safe to serve and returns the directory containing its docs config.
+// Symlinks escaping the checkout are removed, and the docs config must resolve to a file inside it.
+func prepareDocsDir(deploymentDir, docsPath string) (string, error) {
+	root, err := filepath.EvalSymlinks(deploymentDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve deployment directory: %w", err)
+	}
+
+	if err := removeEscapingSymlinks(root); err != nil {
+		return "", fmt.Errorf("failed to sanitize checkout: %w", err)
+	}
+
+	configPath, err := filepath.EvalSymlinks(filepath.Join(root, docsPath))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("%s file not found", docsPath)
+		}
+		return "", fmt.Errorf("failed to resolve %s: %w", docsPath, err)
+	}
+	if !isWithin(root, configPath) {
+		return "", fmt.Errorf("%s resolves outside of the repository", docsPath)
+	}
+
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat %s: %w", docsPath, err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", docsPath)
+	}
+
+	return filepath.Dir(configPath), nil
+}
+
+// removeEscapingSymlinks deletes every symlink under root whose target lies outside of root.
+// Symlinks that stay inside the checkout are left alone since docs sites commonly use them for shared snippets.
+func removeEscapingSymlinks(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			// Dangling link: judge it by where it points lexically.
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if !filepath.IsAbs(link) {
+				link = filepath.Join(filepath.Dir(path), link)
+			}
+			target = filepath.Clean(link)
+		}
+
+		if isWithin(root, target) {
+			return nil
+		}
+
+		log.Warnf("Removing symlink %s pointing outside of the checkout", path)
+		return os.Remove(path)
+	})
+}
+
+// isWithin reports whether path is root or lies beneath it. Both paths must already be resolved.
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || filepath.IsLocal(rel)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsWithin(t *testing.T) {
+	tests := []struct {
+		root, path string
+		want       bool
+	}{
+		{root: "/srv/repos/d1", path: "/srv/repos/d1", want: true},
+		{root: "/srv/repos/d1", path: "/srv/repos/d1/docs/mint.json", want: true},
+		{root: "/srv/repos/d1", path: "/srv/repos/d1/..docs", want: true},
+		{root: "/srv/repos/d1", path: "/srv/repos/d1/../d2", want: false},
+		{root: "/srv/repos/d1", path: "/srv/repos/d10", want: false},
+		{root: "/srv/repos/d1", path: "/srv/repos", want: false},
+		{root: "/srv/repos/d1", path: "/etc/passwd", want: false},
+		{root: "/srv/repos/d1", path: "docs/mint.json", want: false},
+	}
+	for _, tt := range tests {
+		if got := isWithin(tt.root, tt.path); got != tt.want {
+			t.Errorf("isWithin(%q, %q) = %v, want %v", tt.root, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveEscapingSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"docs/nested", ".git"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "docs", "mint.json"), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	links := []struct {
+		name, target string
+		kept         bool
+	}{
+		{name: "docs/config.json", target: "mint.json", kept: true},
+		{name: "docs/nested/up", target: "../mint.json", kept: true},
+		{name: "docs/self", target: ".", kept: true},
+		{name: "docs/dangling", target: "missing.json", kept: true},
+		{name: "docs/absolute", target: filepath.Join(outside, "secret"), kept: false},
+		{name: "docs/relative", target: "../../" + filepath.Base(outside) + "/secret", kept: false},
+		{name: "docs/parent", target: "../..", kept: false},
+		{name: "docs/dangling-outside", target: "../../missing", kept: false},
+		{name: "docs/etc", target: "/etc", kept: false},
+		// git's own files are never served and are left alone.
+		{name: ".git/hook", target: "/etc", kept: true},
+	}
+	for _, l := range links {
+		if err := os.Symlink(l.target, filepath.Join(root, l.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := removeEscapingSymlinks(root); err != nil {
+		t.Fatalf("removeEscapingSymlinks() error = %v", err)
+	}
+	for _, l := range links {
+		_, err := os.Lstat(filepath.Join(root, l.name))
+		if kept := err == nil; kept != l.kept {
+			t.Errorf("symlink %s -> %s kept = %v, want %v", l.name, l.target, kept, l.kept)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret")); err != nil {
+		t.Errorf("target outside the checkout was touched: %v", err)
+	}
+}
+
+func TestPrepareDocsDir(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "docs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "docs", "mint.json"), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/hostname", filepath.Join(root, "escape.json")); err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		docsPath string
+		want     string
+		wantErr  bool
+	}{
+		{docsPath: "docs/mint.json", want: filepath.Join(resolved, "docs")},
+		{docsPath: "escape.json", wantErr: true},
+		{docsPath: "docs", wantErr: true},
+		{docsPath: "missing.json", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := prepareDocsDir(root, tt.docsPath)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("prepareDocsDir(%q) = %q, %v, want %q, error %v", tt.docsPath, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"mintlify-previewer-backend/log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// getEnvInt64 returns the int64 value of the environment variable key, or fallback if it is unset or invalid.
+func getEnvInt64(key string, fallback int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		log.Warnf("Invalid value %q for %s, using default %d", v, key, fallback)
+		return fallback
+	}
+	return n
+}
+
+// getEnvDuration returns the duration value of the environment variable key, or fallback if it is unset or invalid.
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Warnf("Invalid value %q for %s, using default %s", v, key, fallback)
+		return fallback
+	}
+	return d
+}
+
+// getEnvList returns the comma-separated values of the environment variable key, or fallback if it is unset.
+func getEnvList(key string, fallback []string) []string {
+	if v := os.Getenv(key); v != "" {
+		return splitList(v)
+	}
+	return fallback
+}
+
+// getEnv returns the value of the environment variable key, or fallback if it is unset.
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// getEnvInt returns the integer value of the environment variable key, or fallback if it is unset or invalid.
+func getEnvInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Warnf("Invalid value %q for %s, using default %d", v, key, fallback)
+		return fallback
+	}
+	return n
+}
+
+// getEnvBool returns the boolean value of the environment variable key, or fallback if it is unset or invalid.
+func getEnvBool(key string, fallback bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Warnf("Invalid value %q for %s, using default %t", v, key, fallback)
+		return fallback
+	}
+	return b
+}
@@ -1,68 +1,44 @@
 package main
 
 import (
-	"database/sql"
-	"errors"
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/sqlite3"
 	"mintlify-previewer-backend/log"
-	"net/url"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
-
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/mattn/go-sqlite3"
+	"time"
 )
 
 type Deployment struct {
-	UUID      string `json:"uuid"`
-	GitHubURL string `json:"github_url"`
-	Branch    string `json:"branch"`
-	DocsPath  string `json:"docs_path"`
-	DeployURL string `json:"deployment_url"`
-	Status    string `json:"status"`
-}
-
-var db *sql.DB
-
-func initDB() {
-	var err error
-	dbPath := "./.sqlite/deployments.db"
-	dir := "./.sqlite"
-
-	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
-		log.Fatal("failed to create database directory: ", err)
-	}
-
-	db, err = sql.Open("sqlite3", dbPath)
-	if err != nil {
-		log.Fatal("failed to open database: ", err)
-	}
-
-	driver, err := sqlite3.WithInstance(db, &sqlite3.Config{})
-	if err != nil {
-		log.Fatal("failed to create SQLite driver instance: ", err)
-	}
-
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://migrations",
-		"sqlite",
-		driver,
-	)
-	if err != nil {
-		log.Fatal("failed to create migration instance: ", err)
-	}
-
-	err = m.Up()
-	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
-		log.Fatal("failed to apply migrations: ", err)
-	}
-
-	log.Info("Migrations applied successfully!")
+	UUID      string          `json:"uuid"`
+	GitHubURL string          `json:"github_url"`
+	Branch    string          `json:"branch"`
+	DocsPath  string          `json:"docs_path"`
+	DeployURL string          `json:"deployment_url"`
+	Status    DeploymentState `json:"status"`
+
+	// MintlifyVersion pins the Mintlify CLI the preview runs with, the configured default if empty.
+	MintlifyVersion string `json:"mintlify_version,omitempty"`
+	// Mode is deploymentModeDev, the default, or deploymentModeStatic.
+	Mode string `json:"mode,omitempty"`
+	// Builder names the Builder the preview is made with, Mintlify if empty, or builderAuto to detect it.
+	Builder string `json:"builder,omitempty"`
+
+	QueuePosition int        `json:"queue_position,omitempty"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+
+	Access      *DeploymentAccess `json:"access,omitempty"`
+	AccessToken string            `json:"access_token,omitempty"`
+
+	// Stored state that isn't part of the API.
+	upstreamURL  string
+	policy       accessPolicy
+	port         int // 0 if no port is assigned
+	sandboxUID   int // 0 if no sandbox UID is assigned
+	pid          int // 0 if no dev server process is recorded
+	pidStartTime int64
 }
 
+// restoreDeployments re-adopts dev servers that survived a restart and puts every other
+// deployment that was running or starting back in the queue.
 func restoreDeployments() {
 	// TODO: Stop deployments after x number of days
 	dir, err := os.Getwd()
@@ -71,57 +47,55 @@ func restoreDeployments() {
 		return
 	}
 
-	rows, err := db.Query("SELECT uuid, github_url, branch, docs_path, deployment_url, status FROM deployments WHERE status IN ('running', 'starting')")
+	deps, err := store.ListDeployments(StateRunning, StateStarting)
 	if err != nil {
 		log.Fatalf("Failed to query deployments: %v", err)
 	}
-	defer func(rows *sql.Rows) {
-		err2 := rows.Close()
-		if err2 != nil {
-			log.Error("Failed to close rows: ", err2)
-		}
-	}(rows)
 
-	for rows.Next() {
-		var dep Deployment
-		if err := rows.Scan(&dep.UUID, &dep.GitHubURL, &dep.Branch, &dep.DocsPath, &dep.DeployURL, &dep.Status); err != nil {
-			log.Infof("Failed to scan deployment: %v", err)
-			return
+	for _, dep := range deps {
+		// Static builds are served from disk and survive restarts as they are.
+		if dep.Mode == deploymentModeStatic && dep.Status == StateRunning && hasStaticSite(dep.UUID) {
+			continue
 		}
 
-		go func() {
+		if dep.pid != 0 && dep.port != 0 {
+			pid, startTime, port := dep.pid, dep.pidStartTime, dep.port
 
 			deploymentDir := filepath.Join(dir, ".repos", dep.UUID)
-
-			// Check if the deployment directory exists and is not empty (except for Git files)
-			if isEmptyOrOnlyGitFiles(deploymentDir) {
-				log.Infof("Repository not cloned or incomplete for UUID %s. Cloning now...", dep.UUID)
-				_, repoURL := extractPRID(dep.GitHubURL)
-				if out, err := cloneRepo(repoURL, dep.Branch, deploymentDir); err != nil {
-					log.Infof("Failed to clone repository for UUID %s: %v", dep.UUID, out)
-					_, err2 := db.Exec("UPDATE deployments SET status = ?, error = ? WHERE uuid = ?", "failed", err.Error(), dep.UUID)
-					if err2 != nil {
-						log.Infof("Failed to update status for UUID %s: %v for error %+v", dep.UUID, err2, err)
+			_, config, err := resolveBuilder(dep, deploymentDir)
+			var serverDir string
+			if err == nil {
+				serverDir, err = prepareDocsDir(deploymentDir, config)
+			}
+			if err == nil {
+				err = checkAdoptable(pid, startTime, port, serverDir)
+			}
+			if err == nil {
+				adoptMintlifyServer(dep.UUID, pid, startTime, port)
+				if dep.Status != StateRunning {
+					if err := transitionDeployment(dep.UUID, StateRunning, "re-adopted after restart"); err != nil {
+						log.Errorf("Failed to update running status: %v", err)
 					}
-					return
 				}
+				continue
 			}
-
-			mintFilePath := filepath.Join(deploymentDir, dep.DocsPath)
-			if _, err := os.Stat(mintFilePath); os.IsNotExist(err) {
-				_, err2 := db.Exec("UPDATE deployments SET status = ?, error = ? WHERE uuid = ?", "failed", "mint.json file not found", dep.UUID)
-				if err2 != nil {
-					log.Infof("Failed to update status for UUID %s: %v for error %+v", dep.UUID, err2, err)
+			log.Infof("Not re-adopting PID %d for UUID %s: %v", pid, dep.UUID, err)
+
+			// A process we started that no longer qualifies would keep the port busy, so get rid of it.
+			if st, err := processStartTime(pid); err == nil && st == startTime {
+				stale := &mintlifyServer{pid: pid, port: port, done: make(chan struct{})}
+				close(stale.done)
+				if err := stale.stop(); err != nil {
+					log.Errorf("Failed to stop stale dev server for UUID %s: %v", dep.UUID, err)
 				}
-				return
 			}
+		}
 
-			serverDir := filepath.Dir(mintFilePath)
-			port := extractPortFromURL(dep.DeployURL)
-
-			startMintlifyDev(dep.UUID, port, serverDir)
-		}()
+		if err := enqueueDeployment(dep.UUID, "restored after restart"); err != nil {
+			log.Errorf("Failed to re-queue UUID %s: %v", dep.UUID, err)
+		}
 	}
+	restoreFinished.Store(true)
 }
 
 func isEmptyOrOnlyGitFiles(dir string) bool {
@@ -140,25 +114,3 @@ func isEmptyOrOnlyGitFiles(dir string) bool {
 
 	return true // Directory is empty or contains only Git files
 }
-
-func extractPortFromURL(deployURL string) int {
-	parsedURL, err := url.Parse(deployURL)
-	if err != nil {
-		log.Infof("Failed to parse URL %s: %v", deployURL, err)
-		return 0
-	}
-
-	hostParts := strings.Split(parsedURL.Host, ":")
-	if len(hostParts) < 2 {
-		log.Infof("No port found in URL %s", deployURL)
-		return 0
-	}
-
-	port, err := strconv.Atoi(hostParts[1])
-	if err != nil {
-		log.Infof("Failed to convert port to integer: %v", err)
-		return 0
-	}
-
-	return port
-}
@@ -1,21 +1,18 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"html/template"
-	"math/rand"
 	"mintlify-previewer-backend/log"
-	"net"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/oklog/ulid/v2"
 )
@@ -31,12 +28,43 @@ func createDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	effectiveMintJSONPath, err := getValidDocsPath(req.DocsPath)
+	if err := validateGitHubURL(req.GitHubURL); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errRepoNotAllowed) {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if err := validateBranch(req.Branch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateMintlifyVersion(req.MintlifyVersion); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateMode(req.Mode); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = deploymentModeDev
+	}
+	if err := validateBuilderRequest(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	policy, err := newAccessPolicy(req.Access)
 	if err != nil {
-		http.Error(w, "Invalid docs path: "+err.Error(), http.StatusBadRequest)
+		http.Error(w, "Invalid access settings: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !authorizeRepository(w, r, req.GitHubURL) {
 		return
 	}
-	req.DocsPath = effectiveMintJSONPath
 
 	dir, err := os.Getwd()
 	if err != nil {
@@ -46,111 +74,165 @@ func createDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 
 	newUUID := strings.ToLower(ulid.Make().String())
 	req.UUID = newUUID
+	r = tagRequest(r, log.Fields{"uuid": newUUID})
 
-	deploymentDir := filepath.Join(dir, ".repos", req.UUID)
-	if err := os.MkdirAll(deploymentDir, 0755); err != nil {
-		http.Error(w, "Failed to create deployment directory", http.StatusInternalServerError)
+	_, repoURL := extractPRID(req.GitHubURL)
+	ctx := startDeploymentSpan(r, newUUID)
+	err = runStage(ctx, stageCheck, checkTimeout, func(ctx context.Context) error {
+		return checkRepoExists(ctx, repoURL, req.Branch)
+	})
+	if err != nil {
+		endDeploymentSpan(newUUID, err)
+		http.Error(w, fmt.Sprintf("Repository check failed: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	_, repoURL := extractPRID(req.GitHubURL)
-	if err := checkRepoExists(repoURL, req.Branch); err != nil {
-		http.Error(w, fmt.Sprintf("Repository check failed: %v", err), http.StatusInternalServerError)
+	// Only touch the disk once the request has been accepted, rejected ones leave nothing behind.
+	deploymentDir := filepath.Join(dir, ".repos", req.UUID)
+	if err := os.MkdirAll(deploymentDir, 0755); err != nil {
+		endDeploymentSpan(newUUID, err)
+		http.Error(w, "Failed to create deployment directory", http.StatusInternalServerError)
 		return
 	}
 
-	port := getUniquePort()
-	deployURL := fmt.Sprintf("http://localhost:%d", port)
 	reverseProxyURL := fmt.Sprintf("https://%s.%s", newUUID, r.Host)
 
-	_, err = db.Exec("INSERT INTO deployments (uuid, github_url, branch, docs_path, deployment_url, deployment_proxy_url, status) VALUES (?, ?, ?, ?, ?, ?, ?)",
-		newUUID, req.GitHubURL, req.Branch, req.DocsPath, deployURL, reverseProxyURL, "starting")
+	req.DeployURL = reverseProxyURL
+	req.policy = policy
+
+	// The port is taken in the same statement that queues the deployment, so a worker never claims
+	// one that is about to be rolled back. Static previews are served by the previewer itself and
+	// need no port.
+	if req.Mode == deploymentModeStatic {
+		err = store.CreateDeployment(&req)
+	} else {
+		_, err = allocatePort(func(port int) error {
+			req.port, req.upstreamURL = port, portURL(port)
+			return store.CreateDeployment(&req)
+		})
+	}
 	if err != nil {
-		log.Info("failed to create deployment:", err)
+		endDeploymentSpan(newUUID, err)
+		_ = os.RemoveAll(deploymentDir)
+		if errors.Is(err, errPortRangeExhausted) {
+			http.Error(w, "No capacity for another preview, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		log.FromContext(r.Context()).Info("failed to create deployment:", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	response := Deployment{UUID: newUUID, GitHubURL: req.GitHubURL, Branch: req.Branch, DeployURL: reverseProxyURL, Status: "starting"}
+	notifyQueue()
+
+	response := Deployment{UUID: newUUID, GitHubURL: req.GitHubURL, Branch: req.Branch, DeployURL: reverseProxyURL, Status: StateQueued}
+	if position, err := store.QueuePosition(newUUID); err == nil {
+		response.QueuePosition = position
+	}
+	if policy.mode == accessModeToken {
+		response.AccessToken = signPreviewToken(tokenPurpose, newUUID, time.Now().Add(policy.tokenTTL))
+	}
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(response)
 	if err != nil {
-		log.Errorf("Failed to encode response: %v", err)
+		log.FromContext(r.Context()).Errorf("Failed to encode response: %v", err)
 	}
 
-	startProcessing(newUUID, repoURL, req, deploymentDir, port)
 }
 
-func startProcessing(newUUID string, repoURL string, req Deployment, deploymentDir string, port int) {
-	go func() {
-		if err := ensureMintlifyInstalled(); err != nil {
-			log.Infof("Failed to install Mintlify: %v", err)
-			_, _ = db.Exec("UPDATE deployments SET status = ?, error = ? WHERE uuid = ?", "failed", err.Error(), newUUID)
-			return
+// findDeployment looks up a deployment for the management API. It writes the response and returns
+// false if the deployment doesn't exist, has been deleted, or belongs to a repository the API key
+// may not access.
+func findDeployment(w http.ResponseWriter, r *http.Request, uuid string) (*Deployment, bool) {
+	dep, err := store.GetDeployment(uuid)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			http.Error(w, "Deployment not found", http.StatusNotFound)
+			return nil, false
 		}
+		log.FromContext(r.Context()).Info("Failed to query deployment:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if dep.DeletedAt != nil {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return nil, false
+	}
+	if !authorizeRepository(w, r, dep.GitHubURL) {
+		return nil, false
+	}
+	return dep, true
+}
 
-		if _, err := cloneRepo(repoURL, req.Branch, deploymentDir); err != nil {
-			log.Errorln(err)
-			_, _ = db.Exec("UPDATE deployments SET status = ?, error = ? WHERE uuid = ?", "failed", err.Error(), newUUID)
-			return
-		}
+func getDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	uuidParam := chi.URLParam(r, "uuid")
+	dep, ok := findDeployment(w, r, uuidParam)
+	if !ok {
+		return
+	}
 
-		mintFilePath := filepath.Join(deploymentDir, req.DocsPath)
-		if _, err := os.Stat(mintFilePath); os.IsNotExist(err) {
-			_, _ = db.Exec("UPDATE deployments SET status = ?, error = ? WHERE uuid = ?", "failed", "mint.json file not found", newUUID)
-			return
+	var err error
+	if dep.Status == StateQueued {
+		if dep.QueuePosition, err = store.QueuePosition(dep.UUID); err != nil {
+			log.FromContext(r.Context()).Info("Failed to query queue position:", err)
 		}
+	}
 
-		serverDir := filepath.Dir(mintFilePath)
-
-		startMintlifyDev(newUUID, port, serverDir)
-	}()
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(dep)
+	if err != nil {
+		log.FromContext(r.Context()).Error("Failed to encode response:", err)
+	}
 }
 
-func getValidDocsPath(path string) (string, error) {
-	if path == "" {
-		return "", errors.New("docs_path cannot be empty")
+// createAccessTokenHandler issues a fresh access token for a token-protected preview.
+func createAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	uuidParam := chi.URLParam(r, "uuid")
+
+	dep, ok := findDeployment(w, r, uuidParam)
+	if !ok {
+		return
+	}
+	if dep.policy.mode != accessModeToken {
+		http.Error(w, "Deployment is not protected by access tokens", http.StatusConflict)
+		return
+	}
+
+	expiresAt := time.Now().Add(dep.policy.tokenTTL)
+	response := struct {
+		AccessToken string    `json:"access_token"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}{
+		AccessToken: signPreviewToken(tokenPurpose, uuidParam, expiresAt),
+		ExpiresAt:   expiresAt.UTC(),
 	}
-	if !strings.HasSuffix(path, ".json") {
-		return "", errors.New("docs_path must end with .json")
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.FromContext(r.Context()).Error("Failed to encode response:", err)
 	}
-	return path, nil
 }
 
-func getDeploymentHandler(w http.ResponseWriter, r *http.Request) {
-	uuidParam := chi.URLParam(r, "uuid")
-	var dep Deployment
+// deploymentHistoryHandler returns the state transitions of a deployment, oldest first.
+func deploymentHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	uuid := chi.URLParam(r, "uuid")
 
-	err := db.QueryRow("SELECT  uuid, github_url, branch, deployment_proxy_url as deployment_url, status FROM deployments WHERE uuid = ?",
-		uuidParam).Scan(&dep.UUID, &dep.GitHubURL, &dep.Branch, &dep.DeployURL, &dep.Status)
+	if _, ok := findDeployment(w, r, uuid); !ok {
+		return
+	}
+
+	events, err := store.DeploymentHistory(uuid)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "Deployment not found", http.StatusNotFound)
-			return
-		}
-		log.Info("Failed to query deployment:", err)
+		log.FromContext(r.Context()).Info("Failed to query deployment history:", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(dep)
+	err = json.NewEncoder(w).Encode(events)
 	if err != nil {
-		log.Error("Failed to encode response:", err)
-	}
-}
-
-func getUniquePort() int {
-	for {
-		port := 5000 + rand.Intn(1000)
-		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-		if err == nil {
-			err2 := ln.Close()
-			if err2 != nil {
-				log.Error("Failed to close listener:", err2)
-			}
-			return port
-		}
+		log.FromContext(r.Context()).Error("Failed to encode response:", err)
 	}
 }
 
@@ -169,125 +251,370 @@ func extractPRID(githubURL string) (string, string) {
 func deleteDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 	uuid := chi.URLParam(r, "uuid")
 
-	err := stopMintlifyServer(uuid)
+	if _, ok := findDeployment(w, r, uuid); !ok {
+		return
+	}
+
+	if err := deleteDeployment(uuid); err != nil {
+		log.FromContext(r.Context()).Errorf("Failed to delete deployment %s: %v", uuid, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, err := fmt.Fprintf(w, "Deployment %s deleted, it can be restored until %s", uuid, time.Now().Add(deletedRetention).UTC().Format(time.RFC3339))
+	if err != nil {
+		log.FromContext(r.Context()).Error("Failed to write response:", err)
+	}
+}
+
+// restoreDeploymentHandler brings back a deleted deployment while it is within the retention window.
+func restoreDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	uuid := chi.URLParam(r, "uuid")
+
+	dep, err := store.GetDeployment(uuid)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			http.Error(w, err.Error(), http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, errNotFound) {
+			http.Error(w, "Deployment not found", http.StatusNotFound)
+			return
 		}
+		log.FromContext(r.Context()).Info("Failed to query deployment:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !authorizeRepository(w, r, dep.GitHubURL) {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	_, err = fmt.Fprintf(w, "Mintlify server for UUID %s stopped", uuid)
+	if err := restoreDeletedDeployment(dep); err != nil {
+		switch {
+		case errors.Is(err, errNotDeleted), errors.Is(err, errStillShuttingDown):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, errRetentionExpired):
+			http.Error(w, err.Error(), http.StatusGone)
+		default:
+			log.FromContext(r.Context()).Errorf("Failed to restore deployment %s: %v", uuid, err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	dep.Status = StateQueued
+	dep.DeletedAt = nil
+	if dep.QueuePosition, err = store.QueuePosition(uuid); err != nil {
+		log.FromContext(r.Context()).Info("Failed to query queue position:", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(dep)
+	if err != nil {
+		log.FromContext(r.Context()).Error("Failed to encode response:", err)
+	}
+}
+
+// cancelDeploymentHandler aborts a deployment that is queued or still being built.
+func cancelDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	uuid := chi.URLParam(r, "uuid")
+
+	if _, ok := findDeployment(w, r, uuid); !ok {
+		return
+	}
+
+	if err := cancelDeployment(uuid); err != nil {
+		if errors.Is(err, errNotCancellable) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.FromContext(r.Context()).Info("Failed to cancel deployment:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	_, err := fmt.Fprintf(w, "Deployment %s cancelled", uuid)
 	if err != nil {
-		log.Error("Failed to write response:", err)
+		log.FromContext(r.Context()).Error("Failed to write response:", err)
 	}
 }
 
 func proxyOrShowStatus(w http.ResponseWriter, r *http.Request) {
-	host := r.Host
-	hostParts := strings.Split(host, ".")
-	if len(hostParts) < 2 {
+	uuid, ok := previewHostUUID(r.Host)
+	if !ok {
 		http.Error(w, "Invalid hostname", http.StatusBadRequest)
 		return
 	}
-	uuid := strings.ToLower(hostParts[0]) // Extract UUID
+	r = tagRequest(r, log.Fields{"uuid": uuid})
 
-	var status string
-	var deploymentUrl string
-	err := db.QueryRow("SELECT status, deployment_url FROM deployments WHERE uuid = ?", uuid).Scan(&status, &deploymentUrl)
+	rt, err := lookupRoute(uuid)
 	if err != nil {
 		http.Error(w, "Deployment not found", http.StatusNotFound)
 		return
 	}
+	if rt.deleted {
+		renderStatusPage(w, http.StatusGone, statusPage{
+			Title:   "Deployment Deleted",
+			Message: "This documentation preview has been deleted.",
+			Icon:    "🗑️",
+			Color:   "#6b7280",
+		})
+		return
+	}
+	if !authorizePreview(w, r, uuid, rt.access) {
+		return
+	}
+	status := rt.status
 
 	// Handle proxying for running deployments
-	if status == "running" {
-		parsedUrl, err := url.Parse(deploymentUrl)
+	if status == StateRunning {
+		log.FromContext(r.Context()).Debugf("Incoming request URL: %s", r.URL.String())
+		instrumentProxy(w, r, uuid, func(w http.ResponseWriter, r *http.Request) {
+			servePreview(w, r, uuid, rt)
+		})
+		return
+	} else if status == StateStarting || status == StateQueued {
+		var data struct {
+			QueuePosition int
+		}
+		if status == StateQueued {
+			if data.QueuePosition, err = store.QueuePosition(uuid); err != nil {
+				log.FromContext(r.Context()).Error("Failed to query queue position:", err)
+			}
+		}
+
+		tmpl, err := template.ParseFiles("static/loading.html")
 		if err != nil {
-			http.Error(w, "Invalid deployment URL", http.StatusInternalServerError)
+			http.Error(w, "Failed to load template", http.StatusInternalServerError)
 			return
 		}
-
-		if parsedUrl.Scheme == "" {
-			parsedUrl.Scheme = "http"
+		if err := tmpl.Execute(w, data); err != nil {
+			log.FromContext(r.Context()).Error("Failed to load template:", err)
 		}
-
-		log.Infof("Incoming request URL: %s", r.URL.String())
-		log.Infof("Incoming request URL (parsedPath): %s", parsedUrl)
-		proxy := httputil.NewSingleHostReverseProxy(parsedUrl)
-		proxy.ServeHTTP(w, r)
-		return
-	} else if status == "starting" {
-		http.ServeFile(w, r, "static/loading.html")
 		return
 	}
 
 	// Define the status page data for other states
-	var data struct {
-		Title   string
-		Message string
-		Icon    string
-		Color   string
-		Refresh bool
-	}
+	var data statusPage
 
 	switch status {
-	case "failed":
-		data = struct {
-			Title   string
-			Message string
-			Icon    string
-			Color   string
-			Refresh bool
-		}{
+	case StateFailed:
+		data = statusPage{
 			Title:   "Deployment Failed",
 			Message: "Something went wrong while starting the server. If this issue persists, please contact support.",
 			Icon:    "⚠️",
 			Color:   "#ef4444",
-			Refresh: false,
 		}
-	case "stopped":
-		data = struct {
-			Title   string
-			Message string
-			Icon    string
-			Color   string
-			Refresh bool
-		}{
+	case StateCancelled:
+		data = statusPage{
+			Title:   "Deployment Cancelled",
+			Message: "This preview was cancelled before it started.",
+			Icon:    "🚫",
+			Color:   "#6b7280",
+		}
+	case StateStopped:
+		data = statusPage{
 			Title:   "Deployment Stopped",
 			Message: "The documentation preview is currently unavailable.",
 			Icon:    "🛑",
 			Color:   "#6366f1",
-			Refresh: false,
 		}
 	default:
-		data = struct {
-			Title   string
-			Message string
-			Icon    string
-			Color   string
-			Refresh bool
-		}{
+		data = statusPage{
 			Title:   "Unknown Deployment State",
 			Message: "We're unable to determine the current state of your deployment. Please check back later or contact support if this persists.",
 			Icon:    "❓",
 			Color:   "#eab308",
-			Refresh: false,
 		}
 	}
 
-	// Load and render the template
+	renderStatusPage(w, http.StatusOK, data)
+}
+
+// servePreview proxies a request to a running preview, answering from the asset cache when possible.
+func servePreview(w http.ResponseWriter, r *http.Request, uuid string, rt *route) {
+	if rt.site != "" {
+		serveStaticSite(w, r, rt.site)
+		return
+	}
+
+	key := previewAssets.cacheKey(uuid, r)
+	if key == "" {
+		rt.proxy.ServeHTTP(w, r)
+		return
+	}
+	if asset, ok := previewAssets.get(key); ok {
+		asset.serve(w)
+		return
+	}
+
+	rec := &recordingWriter{ResponseWriter: w, maxBytes: previewAssets.maxEntry}
+	rt.proxy.ServeHTTP(rec, r)
+	if asset, ok := rec.asset(key, uuid); ok {
+		previewAssets.add(asset)
+	}
+}
+
+// statusPage is the data of static/status.html.
+type statusPage struct {
+	Title   string
+	Message string
+	Icon    string
+	Color   string
+	Refresh bool
+}
+
+func renderStatusPage(w http.ResponseWriter, code int, data statusPage) {
 	tmpl, err := template.ParseFiles("static/status.html")
 	if err != nil {
 		http.Error(w, "Failed to load template", http.StatusInternalServerError)
 		return
 	}
 
+	w.WriteHeader(code)
 	err = tmpl.Execute(w, data)
 	if err != nil {
 		log.Error("Failed to load template:", err)
 	}
 }
+
+type createAPIKeyRequest struct {
+	Name         string   `json:"name"`
+	Scopes       []string `json:"scopes"`
+	Repositories []string `json:"repositories"`
+}
+
+func createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "name cannot be empty", http.StatusBadRequest)
+		return
+	}
+	if err := validateScopes(req.Scopes); err != nil {
+		http.Error(w, "Invalid scopes: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	k, err := createAPIKey(req.Name, req.Scopes, req.Repositories)
+	if err != nil {
+		log.FromContext(r.Context()).Error("Failed to create API key: ", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(k)
+	if err != nil {
+		log.FromContext(r.Context()).Error("Failed to encode response:", err)
+	}
+}
+
+func listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := store.ListAPIKeys()
+	if err != nil {
+		log.FromContext(r.Context()).Error("Failed to list API keys: ", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(keys)
+	if err != nil {
+		log.FromContext(r.Context()).Error("Failed to encode response:", err)
+	}
+}
+
+func revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	found, err := store.RevokeAPIKey(id)
+	if err != nil {
+		log.FromContext(r.Context()).Error("Failed to revoke API key: ", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// getLogLevelHandler returns the current log level.
+func getLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(logLevelRequest{Level: log.GetLevel().String()})
+	if err != nil {
+		log.FromContext(r.Context()).Error("Failed to encode response:", err)
+	}
+}
+
+// setLogLevelHandler changes the log level until the next restart.
+func setLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var req logLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	level, err := log.ParseLevel(req.Level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	previous := log.GetLevel()
+	log.SetLevel(level)
+	log.FromContext(r.Context()).Warnf("Log level changed from %s to %s", previous, level)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(logLevelRequest{Level: level.String()})
+	if err != nil {
+		log.FromContext(r.Context()).Error("Failed to encode response:", err)
+	}
+}
+
+// recentLogsHandler returns the log entries kept in memory, oldest first. They can be narrowed down to
+// a minimum level, a deployment, and a time given as RFC 3339 or as a duration before now.
+func recentLogsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	minLevel := log.DebugLevel
+	if v := query.Get("level"); v != "" {
+		level, err := log.ParseLevel(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		minLevel = level
+	}
+
+	var since time.Time
+	if v := query.Get("since"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			since = time.Now().Add(-d)
+		} else if since, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "since must be an RFC 3339 time or a duration", http.StatusBadRequest)
+			return
+		}
+	}
+
+	uuid := strings.ToLower(query.Get("uuid"))
+	entries := logBuffer.Entries(func(e log.Entry) bool {
+		return e.Severity() <= minLevel &&
+			!e.Time.Before(since) &&
+			(uuid == "" || e.Fields["uuid"] == uuid)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(entries)
+	if err != nil {
+		log.FromContext(r.Context()).Error("Failed to encode response:", err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mintlify-previewer-backend/log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+)
+
+var (
+	// minFreeDisk is the space that must be left under .repos for the previewer to take new deployments.
+	minFreeDisk = uint64(max(getEnvInt64("MIN_FREE_DISK_MB", 1024), 0)) << 20
+
+	// restoreFinished is set once restoreDeployments has brought back the deployments of the previous run.
+	restoreFinished atomic.Bool
+)
+
+// healthCheck is the result of one readiness check.
+type healthCheck struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type readinessResponse struct {
+	Ready  bool                   `json:"ready"`
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+// readinessChecks are the conditions under which the previewer can take deployments, by name.
+var readinessChecks = map[string]func() error{
+	"database":   checkDatabaseWritable,
+	"migrations": checkMigrations,
+	"git":        func() error { return checkBinary("git") },
+	"mintlify":   func() error { return mintlifyToolchain.check() },
+	"disk":       checkDiskSpace,
+	"restore":    checkRestoreFinished,
+}
+
+// healthzHandler reports that the process is alive and serving requests.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	if _, err := fmt.Fprintln(w, "ok"); err != nil {
+		log.FromContext(r.Context()).Error("Failed to write response:", err)
+	}
+}
+
+// readyzHandler runs every readiness check and reports each of them, answering 503 if any failed.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	resp := readinessResponse{Ready: true, Checks: make(map[string]healthCheck, len(readinessChecks))}
+	for name, check := range readinessChecks {
+		if err := check(); err != nil {
+			resp.Ready = false
+			resp.Checks[name] = healthCheck{Error: err.Error()}
+			log.FromContext(r.Context()).Warnf("Readiness check %s failed: %v", name, err)
+			continue
+		}
+		resp.Checks[name] = healthCheck{OK: true}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.FromContext(r.Context()).Error("Failed to encode response:", err)
+	}
+}
+
+func checkDatabaseWritable() error {
+	return store.CheckWritable()
+}
+
+func checkMigrations() error {
+	current, latest, dirty, err := store.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("migration %d failed halfway", current)
+	}
+	if current < latest {
+		return fmt.Errorf("schema is at version %d, latest is %d", current, latest)
+	}
+	return nil
+}
+
+func checkBinary(name string) error {
+	_, err := exec.LookPath(name)
+	return err
+}
+
+func checkDiskSpace() error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	free, err := diskFree(filepath.Join(dir, ".repos"))
+	if errors.Is(err, os.ErrNotExist) {
+		// Nothing has been cloned yet, the checkouts will land on the working directory's filesystem.
+		free, err = diskFree(dir)
+	}
+	if err != nil {
+		return err
+	}
+	if free < minFreeDisk {
+		return fmt.Errorf("%d MB free under .repos, at least %d MB required", free>>20, minFreeDisk>>20)
+	}
+	return nil
+}
+
+func checkRestoreFinished() error {
+	if !restoreFinished.Load() {
+		return errors.New("deployments of the previous run are still being restored")
+	}
+	return nil
+}
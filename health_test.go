@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadinessWaitsForRestore(t *testing.T) {
+	useTestStore(t)
+	chdirTemp(t)
+	finished := restoreFinished.Load()
+	t.Cleanup(func() { restoreFinished.Store(finished) })
+	restoreFinished.Store(false)
+
+	router := newRouter()
+	readiness := func() (int, readinessResponse) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var resp readinessResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode /readyz: %v", err)
+		}
+		return rec.Code, resp
+	}
+
+	// The process answers health checks while it is still restoring.
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/healthz during restore = %d, want 200", rec.Code)
+	}
+	if code, resp := readiness(); code != http.StatusServiceUnavailable || resp.Checks["restore"].OK {
+		t.Errorf("/readyz during restore = %d %+v, want 503 with the restore check failing", code, resp.Checks["restore"])
+	}
+
+	restoreDeployments()
+	if _, resp := readiness(); !resp.Checks["restore"].OK {
+		t.Errorf("restore check after restore = %+v, want ok", resp.Checks["restore"])
+	}
+}
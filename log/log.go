@@ -94,14 +94,38 @@ func (l Level) ToLogrusLevel() (logrus.Level, error) {
 	}
 }
 
+// Formats accepted by SetFormat.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+const timestampFormat = "2006-01-02 15:04:05"
+
+func fromLogrusLevel(l logrus.Level) Level {
+	switch l {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return FatalLevel
+	case logrus.ErrorLevel:
+		return ErrorLevel
+	case logrus.WarnLevel:
+		return WarnLevel
+	case logrus.InfoLevel:
+		return InfoLevel
+	default:
+		return DebugLevel
+	}
+}
+
 // NewLogger creates and returns a new instance of Logger.
-// The log level is set to ErrorLevel by default.
+// The log level is set to InfoLevel and the format to JSON by default.
 func NewLogger(out io.Writer) *Logger {
 	log := &logrus.Logger{
 		Out: out,
 		Formatter: &logrus.JSONFormatter{
-			TimestampFormat: "2006-01-02 15:04:05",
+			TimestampFormat: timestampFormat,
 		},
+		Hooks:        make(logrus.LevelHooks),
 		Level:        logrus.InfoLevel,
 		ReportCaller: false,
 	}
@@ -164,19 +188,19 @@ func (l *Logger) Fatal(args ...interface{}) {
 }
 
 func (l *Logger) Debugf(format string, args ...interface{}) {
-	l.entry.Debug(fmt.Sprintf(format, args...))
+	l.entry.Debugf(format, args...)
 }
 
 func (l *Logger) Infof(format string, args ...interface{}) {
-	l.entry.Info(fmt.Sprintf(format, args...))
+	l.entry.Infof(format, args...)
 }
 
 func (l *Logger) Warnf(format string, args ...interface{}) {
-	l.entry.Warn(fmt.Sprintf(format, args...))
+	l.entry.Warnf(format, args...)
 }
 
 func (l *Logger) Errorf(format string, args ...interface{}) {
-	l.entry.Error(fmt.Sprintf(format, args...))
+	l.entry.Errorf(format, args...)
 }
 
 func (l *Logger) Errorln(args ...interface{}) {
@@ -196,7 +220,7 @@ func (l *Logger) Println(format string, args ...interface{}) {
 }
 
 func (l *Logger) Fatalf(format string, args ...interface{}) {
-	l.entry.Fatal(fmt.Sprintf(format, args...))
+	l.entry.Fatalf(format, args...)
 }
 
 func (l *Logger) WithError(err error) *logrus.Entry {
@@ -218,6 +242,29 @@ func (l *Logger) SetLevel(v Level) {
 	l.logger.SetLevel(lvl)
 }
 
+// GetLevel returns the current logger level.
+func (l *Logger) GetLevel() Level {
+	return fromLogrusLevel(l.logger.GetLevel())
+}
+
+// SetFormat switches the logger between FormatJSON and FormatText output.
+func (l *Logger) SetFormat(format string) error {
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		l.logger.SetFormatter(&logrus.JSONFormatter{TimestampFormat: timestampFormat})
+	case FormatText:
+		l.logger.SetFormatter(&logrus.TextFormatter{TimestampFormat: timestampFormat, FullTimestamp: true})
+	default:
+		return fmt.Errorf("not a valid log format: %q", format)
+	}
+	return nil
+}
+
+// SetOutput sets the writer the logger writes to.
+func (l *Logger) SetOutput(out io.Writer) {
+	l.logger.SetOutput(out)
+}
+
 // SetPrefix sets logger fields
 func (l *Logger) SetPrefix(value interface{}) {
 	l.entry = l.entry.WithField("source", value)
@@ -0,0 +1,99 @@
+package log
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var _ logrus.Hook = &RingBuffer{}
+
+// Entry is a log entry kept by a RingBuffer.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"msg"`
+	Fields  Fields    `json:"fields,omitempty"`
+
+	level Level
+}
+
+// Severity returns the level of the entry.
+func (e Entry) Severity() Level {
+	return e.level
+}
+
+// RingBuffer is a logrus hook keeping the last entries a logger wrote, fields included.
+// Only entries enabled by the logger's level reach it.
+type RingBuffer struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+// NewRingBuffer returns a RingBuffer holding up to size entries.
+func NewRingBuffer(size int) *RingBuffer {
+	return &RingBuffer{entries: make([]Entry, size)}
+}
+
+// Levels is part of the logrus.Hook interface.
+func (b *RingBuffer) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire is part of the logrus.Hook interface, it stores a copy of the entry.
+func (b *RingBuffer) Fire(e *logrus.Entry) error {
+	if len(b.entries) == 0 {
+		return nil
+	}
+
+	var fields Fields
+	if len(e.Data) > 0 {
+		fields = make(Fields, len(e.Data))
+		for k, v := range e.Data {
+			// Errors don't survive JSON encoding, keep their message instead.
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			fields[k] = v
+		}
+	}
+	entry := Entry{
+		Time:    e.Time,
+		Level:   e.Level.String(),
+		Message: e.Message,
+		Fields:  fields,
+		level:   fromLogrusLevel(e.Level),
+	}
+
+	b.mu.Lock()
+	b.entries[b.next] = entry
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+	b.mu.Unlock()
+	return nil
+}
+
+// Entries returns the kept entries matching keep, oldest first. A nil keep matches every entry.
+func (b *RingBuffer) Entries(keep func(Entry) bool) []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var ordered []Entry
+	if b.full {
+		ordered = append(ordered, b.entries[b.next:]...)
+	}
+	ordered = append(ordered, b.entries[:b.next]...)
+
+	result := make([]Entry, 0, len(ordered))
+	for _, e := range ordered {
+		if keep == nil || keep(e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
@@ -0,0 +1,82 @@
+package log
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// messages returns the messages of entries in order.
+func messages(entries []Entry) []string {
+	var msgs []string
+	for _, e := range entries {
+		msgs = append(msgs, e.Message)
+	}
+	return msgs
+}
+
+func TestRingBufferWraparound(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		fired []string
+		want  []string
+	}{
+		{name: "empty", size: 3},
+		{name: "partly filled", size: 3, fired: []string{"a", "b"}, want: []string{"a", "b"}},
+		{name: "exactly full", size: 3, fired: []string{"a", "b", "c"}, want: []string{"a", "b", "c"}},
+		{name: "wrapped", size: 3, fired: []string{"a", "b", "c", "d", "e"}, want: []string{"c", "d", "e"}},
+		{name: "wrapped twice", size: 3, fired: []string{"a", "b", "c", "d", "e", "f", "g"}, want: []string{"e", "f", "g"}},
+		{name: "disabled", size: 0, fired: []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewRingBuffer(tt.size)
+			for _, msg := range tt.fired {
+				if err := b.Fire(&logrus.Entry{Message: msg, Level: logrus.InfoLevel}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := messages(b.Entries(nil)); !slices.Equal(got, tt.want) {
+				t.Errorf("Entries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRingBufferEntries(t *testing.T) {
+	b := NewRingBuffer(4)
+	now := time.Now()
+	fire := func(level logrus.Level, msg string, data logrus.Fields) {
+		t.Helper()
+		if err := b.Fire(&logrus.Entry{Time: now, Level: level, Message: msg, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fire(logrus.PanicLevel, "panic", nil)
+	fire(logrus.WarnLevel, "warn", logrus.Fields{"error": errors.New("boom"), "uuid": "a"})
+	fire(logrus.TraceLevel, "trace", nil)
+
+	entries := b.Entries(nil)
+	wantLevels := []Level{FatalLevel, WarnLevel, DebugLevel}
+	for i, e := range entries {
+		if e.Severity() != wantLevels[i] {
+			t.Errorf("Severity() of %s = %s, want %s", e.Message, e.Severity(), wantLevels[i])
+		}
+	}
+	if err := entries[1].Fields["error"]; err != "boom" {
+		t.Errorf("error field = %#v, want its message", err)
+	}
+
+	// Entries are copies, later changes to the logged fields don't reach them.
+	data := logrus.Fields{"uuid": "b"}
+	fire(logrus.InfoLevel, "info", data)
+	data["uuid"] = "c"
+	got := b.Entries(func(e Entry) bool { return e.Fields["uuid"] == "b" })
+	if len(got) != 1 || got[0].Message != "info" {
+		t.Errorf("Entries() for uuid b = %v", messages(got))
+	}
+}
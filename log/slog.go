@@ -0,0 +1,133 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var _ slog.Handler = &SlogHandler{}
+
+// SlogHandler is a slog.Handler writing into a Logger, so that libraries using log/slog
+// end up in the same stream as the rest of the program. Attributes become fields, with
+// the names of enclosing groups joined by dots, and records are enriched with the fields
+// of the log entry stored in their context by NewContext.
+type SlogHandler struct {
+	logger *Logger
+	fields Fields
+	groups []string
+}
+
+// NewSlogHandler returns a slog.Handler writing into l.
+func NewSlogHandler(l *Logger) *SlogHandler {
+	return &SlogHandler{logger: l, fields: Fields{}}
+}
+
+// NewSlogLogger returns a slog.Logger writing into l.
+func NewSlogLogger(l *Logger) *slog.Logger {
+	return slog.New(NewSlogHandler(l))
+}
+
+// Enabled reports whether the logger's level lets records of the given level through.
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.logger.IsLevelEnabled(toLogrusLevel(level))
+}
+
+// Handle writes a record as a log entry.
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	entry := h.logger.entry
+	if e, ok := ctx.Value(LoggerContextKey).(*logrus.Entry); ok {
+		entry = entry.WithFields(e.Data)
+	}
+
+	fields := make(Fields, len(h.fields)+r.NumAttrs())
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	prefix := h.prefix()
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(fields, prefix, a)
+		return true
+	})
+
+	entry.WithFields(fields).WithTime(r.Time).Log(toLogrusLevel(r.Level), r.Message)
+	return nil
+}
+
+// WithAttrs returns a handler adding attrs to every record.
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := h.clone()
+	prefix := h.prefix()
+	for _, a := range attrs {
+		addAttr(h2.fields, prefix, a)
+	}
+	return h2
+}
+
+// WithGroup returns a handler nesting the attributes of later records in the group name.
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := h.clone()
+	h2.groups = append(h2.groups, name)
+	return h2
+}
+
+func (h *SlogHandler) clone() *SlogHandler {
+	fields := make(Fields, len(h.fields))
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	return &SlogHandler{logger: h.logger, fields: fields, groups: slices.Clip(h.groups)}
+}
+
+func (h *SlogHandler) prefix() string {
+	if len(h.groups) == 0 {
+		return ""
+	}
+	return strings.Join(h.groups, ".") + "."
+}
+
+// addAttr adds an attribute to fields, flattening groups into dotted keys.
+func addAttr(fields Fields, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		if len(attrs) == 0 {
+			return
+		}
+		// Groups without a key are inlined, as slog.Handler requires.
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range attrs {
+			addAttr(fields, prefix, ga)
+		}
+		return
+	}
+	fields[prefix+a.Key] = a.Value.Any()
+}
+
+// toLogrusLevel maps a slog level to the closest logrus level, rounding down.
+func toLogrusLevel(level slog.Level) logrus.Level {
+	switch {
+	case level < slog.LevelInfo:
+		return logrus.DebugLevel
+	case level < slog.LevelWarn:
+		return logrus.InfoLevel
+	case level < slog.LevelError:
+		return logrus.WarnLevel
+	default:
+		return logrus.ErrorLevel
+	}
+}
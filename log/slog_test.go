@@ -0,0 +1,140 @@
+package log
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+)
+
+// newTestSlogLogger returns a slog.Logger writing into a debug level Logger, and the buffer
+// its entries end up in.
+func newTestSlogLogger() (*Logger, *slog.Logger, *RingBuffer) {
+	l := NewLogger(io.Discard)
+	l.SetLevel(DebugLevel)
+	buffer := NewRingBuffer(8)
+	l.logger.AddHook(buffer)
+	return l, NewSlogLogger(l), buffer
+}
+
+func TestSlogHandlerFields(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func(*slog.Logger)
+		want Fields
+	}{
+		{
+			name: "attributes",
+			log:  func(sl *slog.Logger) { sl.Info("msg", "a", 1, slog.String("b", "x")) },
+			want: Fields{"a": int64(1), "b": "x"},
+		},
+		{
+			name: "with attributes",
+			log:  func(sl *slog.Logger) { sl.With("a", 1).Info("msg", "b", 2) },
+			want: Fields{"a": int64(1), "b": int64(2)},
+		},
+		{
+			name: "with group",
+			log:  func(sl *slog.Logger) { sl.WithGroup("http").Info("msg", "status", 200) },
+			want: Fields{"http.status": int64(200)},
+		},
+		{
+			name: "attributes before and after a group",
+			log:  func(sl *slog.Logger) { sl.With("a", 1).WithGroup("g").With("b", 2).WithGroup("h").Info("msg", "c", 3) },
+			want: Fields{"a": int64(1), "g.b": int64(2), "g.h.c": int64(3)},
+		},
+		{
+			name: "group attribute",
+			log:  func(sl *slog.Logger) { sl.WithGroup("g").Info("msg", slog.Group("req", "id", "r1")) },
+			want: Fields{"g.req.id": "r1"},
+		},
+		{
+			name: "inlined and empty groups",
+			log: func(sl *slog.Logger) {
+				sl.Info("msg", slog.Group("", "a", 1), slog.Group("empty"), slog.Attr{})
+			},
+			want: Fields{"a": int64(1)},
+		},
+		{
+			name: "empty group name",
+			log:  func(sl *slog.Logger) { sl.WithGroup("").Info("msg", "a", 1) },
+			want: Fields{"a": int64(1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, sl, buffer := newTestSlogLogger()
+			tt.log(sl)
+			entries := buffer.Entries(nil)
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+			if !reflect.DeepEqual(entries[0].Fields, tt.want) {
+				t.Errorf("fields = %v, want %v", entries[0].Fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlogHandlerDoesNotShareAttributes(t *testing.T) {
+	_, sl, buffer := newTestSlogLogger()
+	parent := sl.With("a", 1)
+	parent.With("b", 2).Info("child")
+	parent.Info("parent")
+
+	entries := buffer.Entries(nil)
+	if _, ok := entries[1].Fields["b"]; ok || len(entries[1].Fields) != 1 {
+		t.Errorf("fields of the parent logger = %v, want only a", entries[1].Fields)
+	}
+}
+
+func TestSlogHandlerLevels(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		want  Level
+	}{
+		{level: slog.LevelDebug - 4, want: DebugLevel},
+		{level: slog.LevelDebug, want: DebugLevel},
+		{level: slog.LevelInfo, want: InfoLevel},
+		{level: slog.LevelInfo + 2, want: InfoLevel},
+		{level: slog.LevelWarn, want: WarnLevel},
+		{level: slog.LevelError, want: ErrorLevel},
+		{level: slog.LevelError + 4, want: ErrorLevel},
+	}
+	for _, tt := range tests {
+		_, sl, buffer := newTestSlogLogger()
+		sl.Log(context.Background(), tt.level, "msg")
+		entries := buffer.Entries(nil)
+		if len(entries) != 1 || entries[0].Severity() != tt.want {
+			t.Errorf("slog level %s logged as %v, want %v", tt.level, entries, tt.want)
+		}
+	}
+
+	l, sl, buffer := newTestSlogLogger()
+	l.SetLevel(WarnLevel)
+	if sl.Enabled(context.Background(), slog.LevelInfo) || !sl.Enabled(context.Background(), slog.LevelWarn) {
+		t.Error("Enabled() does not follow the logger's level")
+	}
+	sl.Info("dropped")
+	if entries := buffer.Entries(nil); len(entries) != 0 {
+		t.Errorf("got %d entries below the logger's level", len(entries))
+	}
+}
+
+func TestSlogHandlerContextFields(t *testing.T) {
+	l, sl, buffer := newTestSlogLogger()
+	ctx := NewContext(context.Background(), l, Fields{"request_id": "r1", "uuid": "a"})
+	sl.With("uuid", "b").InfoContext(ctx, "msg", "status", 200)
+	sl.Info("no context")
+
+	entries := buffer.Entries(nil)
+	// Attributes of the record take precedence over the fields of the context.
+	want := Fields{"request_id": "r1", "uuid": "b", "status": int64(200)}
+	if !reflect.DeepEqual(entries[0].Fields, want) {
+		t.Errorf("fields = %v, want %v", entries[0].Fields, want)
+	}
+	if _, ok := entries[1].Fields["request_id"]; ok {
+		t.Errorf("fields without a context = %v", entries[1].Fields)
+	}
+}
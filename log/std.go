@@ -1,7 +1,8 @@
 package log
 
 import (
-	"fmt"
+	"io"
+	"log/slog"
 
 	"github.com/sirupsen/logrus"
 )
@@ -43,23 +44,23 @@ func Fatal(args ...interface{}) {
 }
 
 func Debugf(format string, args ...interface{}) {
-	stdLogger.Debug(fmt.Sprintf(format, args...))
+	stdLogger.Debugf(format, args...)
 }
 
 func Infof(format string, args ...interface{}) {
-	stdLogger.Info(fmt.Sprintf(format, args...))
+	stdLogger.Infof(format, args...)
 }
 
 func Warnf(format string, args ...interface{}) {
-	stdLogger.Warn(fmt.Sprintf(format, args...))
+	stdLogger.Warnf(format, args...)
 }
 
 func Errorf(format string, args ...interface{}) {
-	stdLogger.Error(fmt.Sprintf(format, args...))
+	stdLogger.Errorf(format, args...)
 }
 
 func Fatalf(format string, args ...interface{}) {
-	stdLogger.Fatal(fmt.Sprintf(format, args...))
+	stdLogger.Fatalf(format, args...)
 }
 
 func WithError(err error) *logrus.Entry {
@@ -69,3 +70,33 @@ func WithError(err error) *logrus.Entry {
 func WithLogger() *logrus.Logger {
 	return stdLogger.logger
 }
+
+// AddHook adds a hook to the standard logger.
+func AddHook(hook logrus.Hook) {
+	stdLogger.logger.AddHook(hook)
+}
+
+// Slog returns a slog.Logger writing into the standard logger.
+func Slog() *slog.Logger {
+	return NewSlogLogger(stdLogger)
+}
+
+// SetLevel sets the level of the standard logger.
+func SetLevel(v Level) {
+	stdLogger.SetLevel(v)
+}
+
+// GetLevel returns the level of the standard logger.
+func GetLevel() Level {
+	return stdLogger.GetLevel()
+}
+
+// SetFormat sets the output format of the standard logger, FormatJSON or FormatText.
+func SetFormat(format string) error {
+	return stdLogger.SetFormat(format)
+}
+
+// SetOutput sets the writer of the standard logger.
+func SetOutput(out io.Writer) {
+	stdLogger.SetOutput(out)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"maps"
+	"mintlify-previewer-backend/log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/oklog/ulid/v2"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Log outputs selectable with LOG_OUTPUT.
+const (
+	logOutputStdout = "stdout"
+	logOutputFile   = "file"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// logBuffer keeps the most recent log entries for GET /admin/logs.
+var logBuffer = log.NewRingBuffer(max(getEnvInt("LOG_BUFFER_SIZE", 1000), 0))
+
+// initLogging configures the standard logger from LOG_LEVEL, LOG_FORMAT and LOG_OUTPUT, a comma-separated
+// list of outputs. The file output rotates LOG_FILE once it reaches LOG_FILE_MAX_SIZE_MB, keeping
+// LOG_FILE_MAX_BACKUPS compressed backups for at most LOG_FILE_MAX_AGE_DAYS. The last LOG_BUFFER_SIZE
+// entries are also kept in memory.
+// It returns a function closing the log file.
+func initLogging() func() {
+	level, err := log.ParseLevel(getEnv("LOG_LEVEL", "info"))
+	if err != nil {
+		log.Fatal("invalid LOG_LEVEL: ", err)
+	}
+	log.SetLevel(level)
+
+	if err := log.SetFormat(getEnv("LOG_FORMAT", log.FormatJSON)); err != nil {
+		log.Fatal("invalid LOG_FORMAT: ", err)
+	}
+
+	var writers []io.Writer
+	var file *lumberjack.Logger
+	for _, output := range getEnvList("LOG_OUTPUT", []string{logOutputStdout}) {
+		switch output {
+		case logOutputStdout:
+			writers = append(writers, os.Stdout)
+		case logOutputFile:
+			file = &lumberjack.Logger{
+				Filename:   getEnv("LOG_FILE", "./logs/previewer.log"),
+				MaxSize:    getEnvInt("LOG_FILE_MAX_SIZE_MB", 100),
+				MaxBackups: getEnvInt("LOG_FILE_MAX_BACKUPS", 5),
+				MaxAge:     getEnvInt("LOG_FILE_MAX_AGE_DAYS", 30),
+				Compress:   true,
+			}
+			writers = append(writers, file)
+		default:
+			log.Fatalf("Unknown LOG_OUTPUT %q, expected %s or %s", output, logOutputStdout, logOutputFile)
+		}
+	}
+	log.SetOutput(io.MultiWriter(writers...))
+	log.AddHook(logBuffer)
+	// Libraries logging through log/slog write into the same stream.
+	slog.SetDefault(log.Slog())
+
+	return func() {
+		if file != nil {
+			_ = file.Close()
+		}
+	}
+}
+
+type requestLogKey struct{}
+
+// requestLog collects the fields of a request's access log line, including those added by handlers.
+type requestLog struct {
+	fields  log.Fields
+	preview bool // served by a preview rather than the management API
+}
+
+// logRequests gives every request an ID and a logger tagged with it, retrievable with log.FromContext,
+// and writes an access log line once the request has been handled. A request ID sent by the client
+// is kept, so that its logs can be correlated with those of the caller.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = strings.ToLower(ulid.Make().String())
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		fields := log.Fields{
+			"request_id": id,
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"host":       r.Host,
+		}
+		rl := &requestLog{fields: maps.Clone(fields)}
+		ctx := context.WithValue(r.Context(), requestLogKey{}, rl)
+		ctx = log.NewContext(ctx, log.FromContext(ctx), fields)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		entry := log.WithFields(rl.fields).WithFields(log.Fields{
+			"status":      rec.status,
+			"duration_ms": time.Since(start).Milliseconds(),
+		})
+		// A single page load of a preview fetches dozens of assets, which would crowd the management
+		// requests out of logBuffer, so previews are only logged at info level when they fail.
+		if rl.preview && rec.status < http.StatusBadRequest {
+			entry.Debug("request handled")
+		} else {
+			entry.Info("request handled")
+		}
+	})
+}
+
+// logDeploymentRequests tags the logger of requests to /{uuid} routes with the deployment they act on.
+func logDeploymentRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, tagRequest(r, log.Fields{"uuid": chi.URLParam(r, "uuid")}))
+	})
+}
+
+// markPreviewRequest records that a request is served by a preview, for its access log line.
+func markPreviewRequest(r *http.Request) {
+	if rl, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		rl.preview = true
+	}
+}
+
+// tagRequest adds fields to the logger of a request and to its access log line.
+func tagRequest(r *http.Request, fields log.Fields) *http.Request {
+	if rl, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		maps.Copy(rl.fields, fields)
+	}
+	return r.WithContext(log.NewContext(r.Context(), log.FromContext(r.Context()), fields))
+}
+
+// deploymentLogContext returns a context whose logger is tagged with a deployment, and with the
+// trace of its span in ctx if it is being traced, for work done on its behalf outside of a request.
+func deploymentLogContext(ctx context.Context, uuid string) context.Context {
+	fields := log.Fields{"uuid": uuid}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		fields["trace_id"] = sc.TraceID().String()
+	}
+	return log.NewContext(ctx, log.FromContext(ctx), fields)
+}
+
+// validRequestID reports whether a client supplied request ID is safe to log and echo back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"mintlify-previewer-backend/log"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAccessLogLevels(t *testing.T) {
+	buffer := log.NewRingBuffer(16)
+	hooks := log.WithLogger().ReplaceHooks(make(logrus.LevelHooks))
+	t.Cleanup(func() { log.WithLogger().ReplaceHooks(hooks) })
+	log.AddHook(buffer)
+	level := log.GetLevel()
+	log.SetLevel(log.DebugLevel)
+	t.Cleanup(func() { log.SetLevel(level) })
+
+	tests := []struct {
+		name    string
+		preview bool
+		status  int
+		want    log.Level
+	}{
+		{name: "preview-asset", preview: true, status: http.StatusOK, want: log.DebugLevel},
+		{name: "preview-redirect", preview: true, status: http.StatusSeeOther, want: log.DebugLevel},
+		{name: "preview-error", preview: true, status: http.StatusBadGateway, want: log.InfoLevel},
+		{name: "preview-denied", preview: true, status: http.StatusUnauthorized, want: log.InfoLevel},
+		{name: "management", status: http.StatusOK, want: log.InfoLevel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.preview {
+					markPreviewRequest(r)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/"+tt.name, nil)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			entries := buffer.Entries(func(e log.Entry) bool { return e.Fields["path"] == "/"+tt.name })
+			if len(entries) != 1 {
+				t.Fatalf("got %d access log entries, want 1", len(entries))
+			}
+			if got := entries[0].Severity(); got != tt.want {
+				t.Errorf("logged at %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecentLogsFilters(t *testing.T) {
+	buffer := logBuffer
+	t.Cleanup(func() { logBuffer = buffer })
+	logBuffer = log.NewRingBuffer(16)
+
+	now := time.Now()
+	for _, e := range []*logrus.Entry{
+		{Time: now.Add(-2 * time.Hour), Level: logrus.ErrorLevel, Message: "old error", Data: logrus.Fields{"uuid": "a"}},
+		{Time: now.Add(-time.Minute), Level: logrus.DebugLevel, Message: "debug", Data: logrus.Fields{"uuid": "a"}},
+		{Time: now.Add(-time.Minute), Level: logrus.InfoLevel, Message: "info"},
+		{Time: now, Level: logrus.WarnLevel, Message: "warning", Data: logrus.Fields{"uuid": "b"}},
+	} {
+		if err := logBuffer.Fire(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query    string
+		want     []string
+		wantCode int
+	}{
+		{query: "", want: []string{"old error", "debug", "info", "warning"}},
+		{query: "level=info", want: []string{"old error", "info", "warning"}},
+		{query: "level=warning", want: []string{"old error", "warning"}},
+		{query: "level=loud", wantCode: http.StatusBadRequest},
+		{query: "uuid=A", want: []string{"old error", "debug"}},
+		{query: "uuid=c", want: []string{}},
+		{query: "since=1h", want: []string{"debug", "info", "warning"}},
+		{query: "since=" + now.Add(-30*time.Second).Format(time.RFC3339), want: []string{"warning"}},
+		{query: "since=yesterday", wantCode: http.StatusBadRequest},
+		{query: "level=info&uuid=a&since=3h", want: []string{"old error"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			recentLogsHandler(w, httptest.NewRequest(http.MethodGet, "/admin/logs?"+tt.query, nil))
+			if tt.wantCode != 0 {
+				if w.Code != tt.wantCode {
+					t.Errorf("got status %d, want %d", w.Code, tt.wantCode)
+				}
+				return
+			}
+
+			var entries []log.Entry
+			if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, e := range entries {
+				got = append(got, e.Message)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,27 +1,132 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/go-chi/chi/v5"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"mintlify-previewer-backend/log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	// shutdownModeStop stops every preview on shutdown and marks it to be restored on the next start.
+	shutdownModeStop = "stop"
+	// shutdownModeDetach leaves previews running so that the next start can re-adopt them.
+	shutdownModeDetach = "detach"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == sandboxHelperArg {
+		runSandboxHelper(os.Args[2:])
+		return
+	}
+
+	closeLogs := initLogging()
+	defer closeLogs()
+	shutdownTracing := initTracing()
+	previewTokenSecret = loadPreviewTokenSecret()
 	initDB()
-	restoreDeployments()
+	bootstrapAdminKey()
+	mintlifyToolchain.preinstall()
+	startPurgeJanitor()
 
-	r := chi.NewRouter()
-	r.Post("/deploy", createDeploymentHandler)
-	r.Get("/{uuid}", getDeploymentHandler)
-	r.Delete("/{uuid}", deleteDeploymentHandler)
-	r.Get("/*", proxyOrShowStatus) // Handles all paths dynamically
+	r := newRouter()
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
-	log.Infof("Server running on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		log.Infof("Server running on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// Serve health checks while the previous run's deployments come back, /readyz reports
+	// not ready until they have. Workers only start claiming once every running preview is
+	// accounted for, so that they don't hand out its slot.
+	restored := make(chan struct{})
+	go func() {
+		defer close(restored)
+		restoreDeployments()
+		startDeploymentWorkers()
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Info("Shutting down, draining in-flight requests...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second))
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Errorf("Failed to drain HTTP server: %v", err)
+	}
+	// Servers re-adopted after this point would escape the shutdown below.
+	if !restoreFinished.Load() {
+		log.Info("Waiting for deployments to finish restoring...")
+	}
+	<-restored
+	stopDeploymentWorkers()
+
+	switch mode := getEnv("SHUTDOWN_MODE", shutdownModeStop); mode {
+	case shutdownModeDetach:
+		mu.Lock()
+		log.Infof("Leaving %d Mintlify servers running for the next start to re-adopt", len(activeServers))
+		mu.Unlock()
+	default:
+		if mode != shutdownModeStop {
+			log.Warnf("Unknown SHUTDOWN_MODE %q, stopping previews", mode)
+		}
+		stopAllMintlifyServers()
+	}
+	// Flush the spans of requests and deployments that ended during shutdown.
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Errorf("Failed to flush traces: %v", err)
+	}
+	log.Info("Shutdown complete")
+}
+
+// newRouter returns the handler of the previewer: previews on their subdomains, and the management
+// API on any other host.
+func newRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Use(logRequests)
+	r.Use(routePreviewHosts)
+	r.Get("/healthz", healthzHandler)
+	r.Get("/readyz", readyzHandler)
+	r.With(requireScope(scopeDeploy)).Post("/deploy", createDeploymentHandler)
+	d := r.With(logDeploymentRequests)
+	d.With(requireScope(scopeRead)).Get("/{uuid}", getDeploymentHandler)
+	d.With(requireScope(scopeRead)).Get("/{uuid}/history", deploymentHistoryHandler)
+	d.With(requireScope(scopeRead)).Post("/{uuid}/token", createAccessTokenHandler)
+	d.With(requireScope(scopeDelete)).Delete("/{uuid}", deleteDeploymentHandler)
+	d.With(requireScope(scopeDelete)).Post("/{uuid}/cancel", cancelDeploymentHandler)
+	d.With(requireScope(scopeDeploy)).Post("/{uuid}/restore", restoreDeploymentHandler)
+	r.With(requireScope(scopeMetrics)).Handle("/metrics", promhttp.Handler())
+	r.Route("/keys", func(r chi.Router) {
+		r.Use(requireScope(scopeAdmin))
+		r.Post("/", createAPIKeyHandler)
+		r.Get("/", listAPIKeysHandler)
+		r.Delete("/{id}", revokeAPIKeyHandler)
+	})
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(requireScope(scopeAdmin))
+		r.Get("/log-level", getLogLevelHandler)
+		r.Put("/log-level", setLogLevelHandler)
+		r.Get("/logs", recentLogsHandler)
+	})
+	return r
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"mintlify-previewer-backend/log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Deployment stages, as labelled in metrics.
+const (
+	stageCheck   = "check"
+	stageClone   = "clone"
+	stageInstall = "install"
+	stageStart   = "start"
+	stageReady   = "ready"
+	stageBuild   = "build"
+)
+
+// stageNames are the names of the stages in error messages.
+var stageNames = map[string]string{
+	stageCheck:   "repository check",
+	stageClone:   "clone",
+	stageInstall: "toolchain install",
+	stageStart:   "start",
+	stageReady:   "startup",
+	stageBuild:   "static build",
+}
+
+// Metrics are labelled by bounded values such as stage, state or status code, never by deployment.
+var (
+	deploymentsFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "previewer_deployments_finished_total",
+		Help: "Deployments that reached a final state, by state.",
+	}, []string{"status"})
+
+	stageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "previewer_stage_duration_seconds",
+		Help:    "Duration of deployment stages, by stage and result.",
+		Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"stage", "result"})
+
+	proxyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "previewer_proxy_requests_total",
+		Help: "Requests proxied to running previews, by status code.",
+	}, []string{"code"})
+
+	proxyDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "previewer_proxy_request_duration_seconds",
+		Help:    "Latency of requests proxied to running previews, by status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"code"})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "previewer_active_servers",
+		Help: "Mintlify dev servers currently tracked by the previewer.",
+	}, func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return float64(len(activeServers))
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "previewer_ports_assigned",
+		Help: "Ports of the preview port range held by deployments.",
+	}, func() float64 {
+		used, err := store.AssignedPorts()
+		if err != nil {
+			log.Errorf("Failed to count assigned ports: %v", err)
+			return 0
+		}
+		return float64(len(used))
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "previewer_ports_capacity",
+		Help: "Size of the preview port range.",
+	}, func() float64 {
+		return float64(portRangeEnd - portRangeStart + 1)
+	})
+)
+
+// observeStage records how long a stage took since start and how it ended.
+func observeStage(stage string, start time.Time, err error) {
+	result := "ok"
+	switch {
+	case errors.Is(err, errStageTimedOut):
+		result = "timeout"
+	case errors.Is(err, errStageCancelled):
+		result = "cancelled"
+	case err != nil:
+		result = "error"
+	}
+	stageDuration.WithLabelValues(stage, result).Observe(time.Since(start).Seconds())
+}
+
+// observeDeploymentState counts deployments reaching a final state.
+func observeDeploymentState(state DeploymentState) {
+	switch state {
+	case StateStopped, StateFailed, StateCancelled:
+		deploymentsFinished.WithLabelValues(string(state)).Inc()
+	}
+}
+
+// statusRecorder captures the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rw *statusRecorder) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *statusRecorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to hijack websocket upgrades.
+func (rw *statusRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// instrumentProxy wraps a request proxied to a deployment in a span, recording its status code and latency.
+func instrumentProxy(w http.ResponseWriter, r *http.Request, uuid string, serve func(http.ResponseWriter, *http.Request)) {
+	start := time.Now()
+	ctx, span := startProxySpan(r, uuid)
+	rec := &statusRecorder{ResponseWriter: w}
+	serve(rec, r.WithContext(ctx))
+
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	endProxySpan(span, rec.status)
+	code := strconv.Itoa(rec.status)
+	proxyRequests.WithLabelValues(code).Inc()
+	proxyDuration.WithLabelValues(code).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS deployment_events;
+DROP TRIGGER IF EXISTS update_deployments_updated_at ON deployments;
+DROP FUNCTION IF EXISTS set_updated_at();
+DROP TABLE IF EXISTS deployments;
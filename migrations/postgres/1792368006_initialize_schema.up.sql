@@ -0,0 +1,63 @@
+CREATE TABLE IF NOT EXISTS deployments
+(
+    uuid                 TEXT PRIMARY KEY,
+    github_url           TEXT,
+    branch               TEXT,
+    docs_path            TEXT DEFAULT 'mint.json',
+    deployment_url       TEXT,
+    deployment_proxy_url TEXT,
+    status               TEXT,
+    error                TEXT,
+    access_mode          TEXT DEFAULT '',
+    access_username      TEXT,
+    access_password_hash TEXT,
+    access_token_ttl     BIGINT,
+    pid                  INTEGER,
+    pid_start_time       BIGINT,
+    port                 INTEGER,
+    queued_at            TIMESTAMPTZ,
+    created_at           TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at           TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    deleted_at           TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_deployments_port ON deployments (port) WHERE port IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_deployments_queue ON deployments (status, queued_at);
+
+CREATE OR REPLACE FUNCTION set_updated_at() RETURNS TRIGGER AS
+$$
+BEGIN
+    NEW.updated_at = CURRENT_TIMESTAMP;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER update_deployments_updated_at
+    BEFORE UPDATE
+    ON deployments
+    FOR EACH ROW
+EXECUTE FUNCTION set_updated_at();
+
+CREATE TABLE IF NOT EXISTS deployment_events
+(
+    id              BIGSERIAL PRIMARY KEY,
+    deployment_uuid TEXT        NOT NULL,
+    from_status     TEXT        NOT NULL,
+    to_status       TEXT        NOT NULL,
+    reason          TEXT DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_deployment_events_deployment ON deployment_events (deployment_uuid, id);
+
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id           TEXT PRIMARY KEY,
+    name         TEXT        NOT NULL,
+    key_hash     TEXT        NOT NULL UNIQUE,
+    scopes       TEXT        NOT NULL,
+    repositories TEXT        DEFAULT '',
+    created_at   TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_deployments_sandbox_uid;
+
+ALTER TABLE deployments DROP COLUMN sandbox_uid;
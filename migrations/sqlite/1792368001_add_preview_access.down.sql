@@ -0,0 +1,4 @@
+ALTER TABLE deployments DROP COLUMN access_token_ttl;
+ALTER TABLE deployments DROP COLUMN access_password_hash;
+ALTER TABLE deployments DROP COLUMN access_username;
+ALTER TABLE deployments DROP COLUMN access_mode;
@@ -0,0 +1,4 @@
+ALTER TABLE deployments ADD COLUMN access_mode TEXT DEFAULT '';
+ALTER TABLE deployments ADD COLUMN access_username TEXT;
+ALTER TABLE deployments ADD COLUMN access_password_hash TEXT;
+ALTER TABLE deployments ADD COLUMN access_token_ttl INTEGER;
@@ -0,0 +1,2 @@
+ALTER TABLE deployments DROP COLUMN pid_start_time;
+ALTER TABLE deployments DROP COLUMN pid;
@@ -0,0 +1,2 @@
+ALTER TABLE deployments ADD COLUMN pid INTEGER;
+ALTER TABLE deployments ADD COLUMN pid_start_time INTEGER;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_deployments_port;
+
+ALTER TABLE deployments DROP COLUMN port;
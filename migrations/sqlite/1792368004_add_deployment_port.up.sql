@@ -0,0 +1,14 @@
+ALTER TABLE deployments ADD COLUMN port INTEGER;
+
+UPDATE deployments
+SET port = CAST(substr(deployment_url, length('http://localhost:') + 1) AS INTEGER)
+WHERE status IN ('running', 'starting')
+  AND deployment_url LIKE 'http://localhost:%';
+
+-- Earlier allocations were racy, keep only the oldest holder of a port.
+UPDATE deployments
+SET port = NULL
+WHERE port IS NOT NULL
+  AND rowid NOT IN (SELECT MIN(rowid) FROM deployments WHERE port IS NOT NULL GROUP BY port);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_deployments_port ON deployments (port) WHERE port IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_deployments_queue;
+
+ALTER TABLE deployments DROP COLUMN queued_at;
@@ -0,0 +1,3 @@
+ALTER TABLE deployments ADD COLUMN queued_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS idx_deployments_queue ON deployments (status, queued_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_deployment_events_deployment;
+
+DROP TABLE IF EXISTS deployment_events;
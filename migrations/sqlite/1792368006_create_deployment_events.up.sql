@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS deployment_events
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    deployment_uuid TEXT     NOT NULL,
+    from_status     TEXT     NOT NULL,
+    to_status       TEXT     NOT NULL,
+    reason          TEXT DEFAULT '',
+    created_at      DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_deployment_events_deployment ON deployment_events (deployment_uuid, id);
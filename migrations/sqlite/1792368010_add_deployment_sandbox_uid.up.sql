@@ -0,0 +1,3 @@
+ALTER TABLE deployments ADD COLUMN sandbox_uid INTEGER;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_deployments_sandbox_uid ON deployments (sandbox_uid) WHERE sandbox_uid IS NOT NULL;
@@ -1,80 +1,325 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"mintlify-previewer-backend/log"
-	"os"
-	"os/exec"
+	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
-var activeServers = make(map[string]*os.Process)
+var activeServers = make(map[string]*mintlifyServer)
 var mu sync.Mutex
 
-func ensureMintlifyInstalled() error {
-	if _, err := exec.LookPath("mintlify"); err != nil {
-		log.Errorln("Mintlify not found, installing...")
-		cmd := exec.Command("npm", "install", "-g", "mintlify")
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		return cmd.Run()
-	}
-	return nil
+var stopTimeout = getEnvDuration("STOP_TIMEOUT", 10*time.Second)
+
+var errServerNotFound = errors.New("server not found")
+
+// mintlifyServer is a running dev server. Its PID doubles as the ID of the process group
+// holding the Node processes it spawned.
+type mintlifyServer struct {
+	pid      int
+	port     int
+	done     chan struct{} // closed once the group leader has exited
+	stopping atomic.Bool
 }
 
-func startMintlifyDev(uuid string, port int, dir string) {
-	cmd := exec.Command("mintlify", "dev", "--no-open", "--port", strconv.Itoa(port))
-	cmd.Dir = dir
+// startDevServer runs the dev server of a deployment, as started by its builder's command, until it exits.
+// The deployment is marked running once the server accepts connections; if that takes longer than
+// startupTimeout, or ctx is cancelled first, the server is stopped and the deployment marked failed
+// or cancelled.
+func startDevServer(ctx context.Context, uuid string, command []string, port int, dir string) {
+	logger := log.FromContext(ctx)
+	start := time.Now()
+	_, span := startStageSpan(ctx, stageStart)
+	cmd, sb, err := newSandboxedCommand(uuid, dir, command[0], command[1:]...)
+	if err != nil {
+		observeStage(stageStart, start, err)
+		recordSpanError(span, err)
+		span.End()
+		recordSpanError(trace.SpanFromContext(ctx), err)
+		logger.Errorf("Failed to prepare dev server sandbox: %v", err)
+		finishBuild(uuid)
+		if err := transitionDeployment(uuid, StateFailed, err.Error()); err != nil {
+			logger.Errorf("Failed to update failed status: %v", err)
+		}
+		return
+	}
+	defer sb.cleanup()
 
-	if err := cmd.Start(); err != nil {
-		log.Errorf("Failed to start Mintlify: %v", err)
-		_, _ = db.Exec("UPDATE deployments SET status = ? WHERE uuid = ?", "failed", uuid)
+	err = cmd.Start()
+	observeStage(stageStart, start, err)
+	recordSpanError(span, err)
+	span.End()
+	if err != nil {
+		recordSpanError(trace.SpanFromContext(ctx), err)
+		logger.Errorf("Failed to start dev server: %v", err)
+		finishBuild(uuid)
+		if err := transitionDeployment(uuid, StateFailed, "failed to start dev server: "+err.Error()); err != nil {
+			logger.Errorf("Failed to update failed status: %v", err)
+		}
 		return
 	}
+	sb.started()
 
+	srv := &mintlifyServer{pid: cmd.Process.Pid, port: port, done: make(chan struct{})}
 	mu.Lock()
-	activeServers[uuid] = cmd.Process
+	activeServers[uuid] = srv
 	mu.Unlock()
+	recordDeploymentProcess(uuid, srv.pid)
+
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- cmd.Wait()
+		close(srv.done)
+	}()
 
-	log.Infof("Mintlify running for UUID %s on port %d", uuid, port)
-	_, err := db.Exec("UPDATE deployments SET status = ? WHERE uuid = ?", "running", uuid)
+	err = runStage(ctx, stageReady, startupTimeout, func(ctx context.Context) error {
+		return waitUntilListening(ctx, srv)
+	})
+	if err == nil {
+		err = finishBuildRunning(ctx, uuid)
+	}
 	if err != nil {
-		log.Errorf("Failed to update running status: %v", err)
+		// A server stopped by stopAllMintlifyServers stays starting, to be restored on the next start.
+		stoppedForShutdown := srv.stopping.Load() && ctx.Err() == nil
+		if !srv.exited() && !srv.stopping.Load() {
+			if err := srv.stop(); err != nil {
+				logger.Errorf("Failed to stop dev server for UUID %s: %v", uuid, err)
+			}
+		}
+		<-srv.done
+		// A server killed for hitting a resource limit during startup fails for that reason.
+		if reason := sb.violation(); reason != "" {
+			err = errors.New(reason)
+		}
+		if !stoppedForShutdown {
+			failBuild(ctx, uuid, err)
+		}
+		finishBuild(uuid)
+		forgetMintlifyServer(uuid, srv)
+		return
 	}
 
-	err = cmd.Wait()
-	if err != nil {
-		log.Errorf("Failed to start Mintlify: %v", err)
+	logger.Infof("Dev server running for UUID %s on port %d", uuid, port)
+
+	err = <-waitErr
+	if !srv.stopping.Load() {
+		reason := sb.violation()
+		if reason != "" {
+			logger.Errorf("Dev server for UUID %s hit a resource limit: %s", uuid, reason)
+		} else {
+			reason = "dev server exited unexpectedly"
+			if err != nil {
+				reason += ": " + err.Error()
+			}
+			logger.Errorf("Dev server for UUID %s exited: %v", uuid, err)
+		}
+		if err := transitionDeployment(uuid, StateFailed, reason); err != nil {
+			logger.Errorf("Failed to update failed status: %v", err)
+		}
 	}
 
+	forgetMintlifyServer(uuid, srv)
+}
+
+// adoptMintlifyServer tracks a dev server that survived a restart of the previewer.
+// The process is not our child, so its exit is detected by polling instead of Wait.
+func adoptMintlifyServer(uuid string, pid int, startTime int64, port int) {
+	srv := &mintlifyServer{pid: pid, port: port, done: make(chan struct{})}
+	mu.Lock()
+	activeServers[uuid] = srv
+	mu.Unlock()
+	previewSlots.forceAcquire()
+	logger := log.FromContext(deploymentLogContext(context.Background(), uuid))
+	logger.Infof("Re-adopted dev server for UUID %s (PID %d) on port %d", uuid, pid, port)
+
+	sb := adoptSandbox(uuid)
+
+	go func() {
+		defer previewSlots.release()
+		defer sb.cleanup()
+		for {
+			time.Sleep(2 * time.Second)
+			if st, err := processStartTime(pid); err != nil || st != startTime {
+				break
+			}
+		}
+		close(srv.done)
+		if !srv.stopping.Load() {
+			reason := sb.violation()
+			if reason != "" {
+				logger.Errorf("Adopted dev server for UUID %s hit a resource limit: %s", uuid, reason)
+			} else {
+				reason = "dev server exited unexpectedly"
+				logger.Errorf("Adopted dev server for UUID %s exited", uuid)
+			}
+			if err := transitionDeployment(uuid, StateFailed, reason); err != nil {
+				logger.Errorf("Failed to update failed status: %v", err)
+			}
+		}
+		forgetMintlifyServer(uuid, srv)
+	}()
+}
+
+// forgetMintlifyServer removes an exited dev server from activeServers and clears its recorded process.
+func forgetMintlifyServer(uuid string, srv *mintlifyServer) {
 	mu.Lock()
-	delete(activeServers, uuid)
+	current := activeServers[uuid] == srv
+	if current {
+		delete(activeServers, uuid)
+	}
 	mu.Unlock()
+
+	if current {
+		if err := store.ClearDeploymentProcess(uuid, srv.pid); err != nil {
+			log.Errorf("Failed to clear process of UUID %s: %v", uuid, err)
+		}
+	}
+}
+
+// recordDeploymentProcess persists the PID and start time of a dev server so that it can be re-adopted after a restart.
+func recordDeploymentProcess(uuid string, pid int) {
+	startTime, err := processStartTime(pid)
+	if err != nil {
+		log.Warnf("Failed to read start time of PID %d, it won't be re-adopted: %v", pid, err)
+		return
+	}
+	if err := store.SetDeploymentProcess(uuid, pid, startTime); err != nil {
+		log.Errorf("Failed to record process of UUID %s: %v", uuid, err)
+	}
 }
 
 func stopMintlifyServer(uuid string) error {
 	mu.Lock()
-	process, exists := activeServers[uuid]
+	srv, exists := activeServers[uuid]
 	mu.Unlock()
 
 	if !exists {
-		log.Errorf("server for UUID %s not found", uuid)
-		return fmt.Errorf("server for UUID %s not found", uuid)
+		return fmt.Errorf("%w for UUID %s", errServerNotFound, uuid)
 	}
 
-	if err := process.Signal(syscall.SIGTERM); err != nil {
-		log.Errorf("Failed to stop Mintlify server: %v", err)
+	if err := srv.stop(); err != nil {
+		log.Errorf("Failed to stop dev server: %v", err)
 		return fmt.Errorf("failed to stop server for UUID %s: %v", uuid, err)
 	}
 
-	mu.Lock()
-	delete(activeServers, uuid)
-	mu.Unlock()
+	forgetMintlifyServer(uuid, srv)
 
-	log.Infof("Mintlify server for UUID %s stopped", uuid)
-	_, err := db.Exec("UPDATE deployments SET status = ? WHERE uuid = ?", "stopped", uuid)
+	log.Infof("Dev server for UUID %s stopped", uuid)
+	err := transitionDeployment(uuid, StateStopped, "stopped on request")
 
 	return err
 }
+
+// stop terminates the whole process group, escalating to SIGKILL if it is still alive after stopTimeout,
+// and returns once the dev server's port has been released.
+func (srv *mintlifyServer) stop() error {
+	srv.stopping.Store(true)
+
+	if err := signalProcessGroup(srv.pid, syscall.SIGTERM); err != nil {
+		return err
+	}
+	if !waitFor(stopTimeout, srv.exited) {
+		log.Warnf("Process group %d did not exit within %s, sending SIGKILL", srv.pid, stopTimeout)
+		if err := signalProcessGroup(srv.pid, syscall.SIGKILL); err != nil {
+			return err
+		}
+		if !waitFor(stopTimeout, srv.exited) {
+			return fmt.Errorf("process group %d survived SIGKILL", srv.pid)
+		}
+	}
+
+	if !waitFor(stopTimeout, func() bool { return portFree(srv.port) }) {
+		return fmt.Errorf("port %d is still in use", srv.port)
+	}
+	return nil
+}
+
+// exited reports whether the group leader has been reaped and no other member is left.
+func (srv *mintlifyServer) exited() bool {
+	select {
+	case <-srv.done:
+		return !processGroupAlive(srv.pid)
+	default:
+		return false
+	}
+}
+
+// waitUntilListening waits for the dev server to accept connections on its port.
+// It fails if the server exits first.
+func waitUntilListening(ctx context.Context, srv *mintlifyServer) error {
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(srv.port))
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			_ = conn.Close()
+			return nil
+		}
+		select {
+		case <-srv.done:
+			return errors.New("dev server exited during startup")
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// waitFor polls cond until it holds or timeout elapses.
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// portFree reports whether nothing is listening on port.
+func portFree(port int) bool {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	_ = ln.Close()
+	return true
+}
+
+// stopAllMintlifyServers stops every dev server and marks its deployment as starting,
+// so that restoreDeployments brings it back on the next start.
+func stopAllMintlifyServers() {
+	mu.Lock()
+	servers := maps.Clone(activeServers)
+	mu.Unlock()
+
+	var wg sync.WaitGroup
+	for uuid, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.stop(); err != nil {
+				log.Errorf("Failed to stop dev server for UUID %s: %v", uuid, err)
+			}
+			// Servers that were still starting up already are in the state restoreDeployments looks for.
+			err := transitionDeployment(uuid, StateStarting, "previewer shutting down")
+			if err != nil && !errors.Is(err, errInvalidTransition) {
+				log.Errorf("Failed to mark UUID %s for restore: %v", uuid, err)
+			}
+		}()
+	}
+	wg.Wait()
+	log.Infof("Stopped %d dev servers", len(servers))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mintlify-previewer-backend/log"
+)
+
+var (
+	portRangeStart = getEnvInt("PORT_RANGE_START", 5000)
+	portRangeEnd   = getEnvInt("PORT_RANGE_END", 5999)
+
+	errPortRangeExhausted = errors.New("no free port left in the preview port range")
+)
+
+// reservePort assigns a free port from the configured range to a deployment and records it
+// along with the deployment URL. The unique index on deployments.port makes each assignment
+// atomic, so concurrent reservations can never hand out the same port.
+func reservePort(uuid string) (int, error) {
+	return allocatePort(func(port int) error {
+		if err := store.AssignPort(uuid, port, portURL(port)); err != nil {
+			return err
+		}
+		invalidateRoute(uuid)
+		return nil
+	})
+}
+
+// allocatePort calls assign with the free ports of the configured range until one of them
+// is recorded, moving on to the next one whenever assign returns errPortTaken.
+func allocatePort(assign func(port int) error) (int, error) {
+	used, err := store.AssignedPorts()
+	if err != nil {
+		return 0, err
+	}
+
+	for port := portRangeStart; port <= portRangeEnd; port++ {
+		if used[port] || !portFree(port) {
+			continue
+		}
+
+		err := assign(port)
+		if err == nil {
+			return port, nil
+		}
+		if errors.Is(err, errPortTaken) {
+			// Another deployment took it since we listed the assigned ports.
+			continue
+		}
+		return 0, err
+	}
+
+	log.Warnf("Port range %d-%d is exhausted", portRangeStart, portRangeEnd)
+	return 0, errPortRangeExhausted
+}
+
+// portURL returns the URL the dev server listening on port is proxied to.
+func portURL(port int) string {
+	return fmt.Sprintf("http://localhost:%d", port)
+}
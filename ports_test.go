@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// staleStore hides assigned ports, as if other deployments took them after reservePort listed them.
+type staleStore struct {
+	Store
+}
+
+func (staleStore) AssignedPorts() (map[int]bool, error) {
+	return map[int]bool{}, nil
+}
+
+// usePortRange points reservePort at n consecutive ports that are free on this machine.
+func usePortRange(t *testing.T, n int) int {
+	t.Helper()
+	start, end := portRangeStart, portRangeEnd
+	t.Cleanup(func() { portRangeStart, portRangeEnd = start, end })
+
+	for first := 42000; first < 43000; first += n {
+		free := true
+		for port := first; port < first+n && free; port++ {
+			free = portFree(port)
+		}
+		if free {
+			portRangeStart, portRangeEnd = first, first+n-1
+			return first
+		}
+	}
+	t.Skip("no free port range to test with")
+	return 0
+}
+
+func TestReservePortExhaustion(t *testing.T) {
+	s := useTestStore(t)
+	first := usePortRange(t, 3)
+
+	for i := range 4 {
+		createTestDeployment(t, s, fmt.Sprintf("dep-%d", i), StateStarting)
+	}
+	for i := range 3 {
+		port, err := reservePort(fmt.Sprintf("dep-%d", i))
+		if err != nil || port != first+i {
+			t.Fatalf("reservePort(dep-%d) = %d, %v, want %d", i, port, err, first+i)
+		}
+	}
+	if port, err := reservePort("dep-3"); !errors.Is(err, errPortRangeExhausted) {
+		t.Fatalf("reservePort() on a full range = %d, %v, want errPortRangeExhausted", port, err)
+	}
+
+	// A stopped deployment gives its port back to the range.
+	if err := s.TransitionDeployment("dep-1", StateFailed, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if port, err := reservePort("dep-3"); err != nil || port != first+1 {
+		t.Errorf("reservePort() after a release = %d, %v, want %d", port, err, first+1)
+	}
+}
+
+func TestReservePortCollisions(t *testing.T) {
+	s := useTestStore(t)
+	first := usePortRange(t, 4)
+	for _, uuid := range []string{"dep-a", "dep-b", "dep-c"} {
+		createTestDeployment(t, s, uuid, StateStarting)
+	}
+
+	// Ports held by something else on the machine are skipped.
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	if port, err := reservePort("dep-a"); err != nil || port != first+1 {
+		t.Fatalf("reservePort(dep-a) = %d, %v, want %d", port, err, first+1)
+	}
+	if port, err := reservePort("dep-b"); err != nil || port != first+2 {
+		t.Fatalf("reservePort(dep-b) = %d, %v, want %d", port, err, first+2)
+	}
+
+	// A port taken between listing and assigning is caught by the unique index and skipped.
+	store = staleStore{s}
+	if port, err := reservePort("dep-c"); err != nil || port != first+3 {
+		t.Fatalf("reservePort(dep-c) with a stale listing = %d, %v, want %d", port, err, first+3)
+	}
+
+	used, err := s.AssignedPorts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(used) != 3 || !used[first+1] || !used[first+2] || !used[first+3] {
+		t.Errorf("AssignedPorts() = %v, want one port per deployment", used)
+	}
+}
+
+func TestCreateDeploymentWithPort(t *testing.T) {
+	s := useTestStore(t)
+	first := usePortRange(t, 1)
+
+	create := func(uuid string) error {
+		dep := &Deployment{UUID: uuid, Mode: deploymentModeDev}
+		_, err := allocatePort(func(port int) error {
+			dep.port, dep.upstreamURL = port, portURL(port)
+			return s.CreateDeployment(dep)
+		})
+		return err
+	}
+	if err := create("dep-a"); err != nil {
+		t.Fatalf("creating dep-a error = %v", err)
+	}
+	if dep, err := s.GetDeployment("dep-a"); err != nil || dep.port != first {
+		t.Fatalf("GetDeployment(dep-a) = %+v, %v, want port %d", dep, err, first)
+	}
+
+	// Without a port nothing is queued for a worker to pick up.
+	if err := create("dep-b"); !errors.Is(err, errPortRangeExhausted) {
+		t.Fatalf("creating dep-b on a full range error = %v, want errPortRangeExhausted", err)
+	}
+	if deps, err := s.ListDeployments(StateQueued); err != nil || len(deps) != 1 {
+		t.Errorf("ListDeployments(queued) = %v, %v, want only dep-a", deps, err)
+	}
+}
@@ -0,0 +1,40 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// signalProcessGroup sends sig to every process in the group led by pgid.
+// A group that has already exited is not an error.
+func signalProcessGroup(pgid int, sig syscall.Signal) error {
+	err := syscall.Kill(-pgid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+// processGroupAlive reports whether any process of the group led by pgid is still around.
+func processGroupAlive(pgid int) bool {
+	err := syscall.Kill(-pgid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// killProcessGroupOnCancel starts cmd in its own process group and makes context cancellation
+// kill the whole group, so that helpers spawned by git or npm don't outlive it.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		return signalProcessGroup(cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Don't wait forever on pipes held open by processes that escaped the group.
+	cmd.WaitDelay = 5 * time.Second
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// route is the cached routing state for a single deployment.
+// Only running deployments carry a proxy, or a site directory for static ones; other states are
+// rendered as status pages.
+type route struct {
+	status    DeploymentState
+	deleted   bool
+	access    accessPolicy
+	proxy     *httputil.ReverseProxy
+	transport *http.Transport
+	site      string
+}
+
+var (
+	routes   = make(map[string]*route)
+	routesMu sync.RWMutex
+
+	// routesGen is bumped on every invalidation so that a lookup which raced
+	// with a status change does not store a stale route.
+	routesGen uint64
+)
+
+// routePreviewHosts sends requests for preview subdomains to the preview, ahead of the management
+// routes, whose paths would otherwise shadow pages of the docs site such as /quickstart or /x/history.
+func routePreviewHosts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := previewHostUUID(r.Host); ok {
+			markPreviewRequest(r)
+			proxyOrShowStatus(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// previewHostUUID returns the deployment whose preview subdomain host is. Deployment IDs are ULIDs,
+// which the hostname the management API is served on doesn't start with.
+func previewHostUUID(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	label, _, ok := strings.Cut(host, ".")
+	if !ok || len(label) != ulid.EncodedSize {
+		return "", false
+	}
+	if _, err := ulid.ParseStrict(label); err != nil {
+		return "", false
+	}
+	return strings.ToLower(label), true
+}
+
+// lookupRoute returns the routing state for a deployment, querying the database only on a cache miss.
+func lookupRoute(uuid string) (*route, error) {
+	routesMu.RLock()
+	rt, ok := routes[uuid]
+	gen := routesGen
+	routesMu.RUnlock()
+	if ok {
+		return rt, nil
+	}
+
+	dep, err := store.GetDeployment(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	rt = &route{status: dep.Status, access: dep.policy, deleted: dep.DeletedAt != nil}
+	if dep.Status == StateRunning && !rt.deleted && dep.Mode == deploymentModeStatic {
+		if rt.site, err = siteDir(uuid); err != nil {
+			return nil, err
+		}
+	} else if dep.Status == StateRunning && !rt.deleted {
+		parsedUrl, err := url.Parse(dep.upstreamURL)
+		if err != nil {
+			return nil, err
+		}
+		if parsedUrl.Scheme == "" {
+			parsedUrl.Scheme = "http"
+		}
+
+		rt.transport = newUpstreamTransport()
+		rt.proxy = httputil.NewSingleHostReverseProxy(parsedUrl)
+		rt.proxy.Transport = rt.transport
+		director := rt.proxy.Director
+		rt.proxy.Director = func(r *http.Request) {
+			director(r)
+			stripPreviewCredentials(r)
+		}
+	}
+
+	routesMu.Lock()
+	defer routesMu.Unlock()
+	if existing, ok := routes[uuid]; ok {
+		rt.close()
+		return existing, nil
+	}
+	if gen == routesGen {
+		routes[uuid] = rt
+	} else if rt.transport != nil {
+		// The route may be stale and only serves the current request, so don't let it keep
+		// idle connections nobody would close.
+		rt.transport.DisableKeepAlives = true
+	}
+	return rt, nil
+}
+
+// invalidateRoute drops the cached route and cached assets of a deployment.
+// It must be called whenever the deployment's status or upstream changes.
+func invalidateRoute(uuid string) {
+	routesMu.Lock()
+	rt, ok := routes[uuid]
+	delete(routes, uuid)
+	routesGen++
+	routesMu.Unlock()
+
+	if ok {
+		rt.close()
+	}
+	previewAssets.purge(uuid)
+}
+
+func (rt *route) close() {
+	if rt.transport != nil {
+		rt.transport.CloseIdleConnections()
+	}
+}
+
+// newUpstreamTransport returns a transport with a connection pool dedicated to a single dev server.
+func newUpstreamTransport() *http.Transport {
+	return &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          64,
+		MaxIdleConnsPerHost:   64,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
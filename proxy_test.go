@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/oklog/ulid/v2"
+)
+
+func TestPreviewHostUUID(t *testing.T) {
+	id := ulid.Make().String()
+	tests := []struct {
+		host   string
+		want   string
+		wantOK bool
+	}{
+		{host: strings.ToLower(id) + ".previews.example.com", want: strings.ToLower(id), wantOK: true},
+		{host: id + ".localhost:8080", want: strings.ToLower(id), wantOK: true},
+		{host: "previews.example.com"},
+		{host: "localhost:8080"},
+		{host: id},
+		{host: "api.example.com"},
+		{host: "u" + strings.ToLower(id)[1:] + ".example.com"},
+	}
+	for _, tt := range tests {
+		got, ok := previewHostUUID(tt.host)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("previewHostUUID(%q) = %q, %v, want %q, %v", tt.host, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestPreviewHostsBypassManagementRoutes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "preview %s %s", r.Method, r.URL.Path)
+	}))
+	defer upstream.Close()
+	target, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uuid := strings.ToLower(ulid.Make().String())
+	routesMu.Lock()
+	routes[uuid] = &route{status: StateRunning, proxy: httputil.NewSingleHostReverseProxy(target)}
+	routesMu.Unlock()
+	t.Cleanup(func() {
+		routesMu.Lock()
+		delete(routes, uuid)
+		routesMu.Unlock()
+	})
+
+	router := newRouter()
+	for _, path := range []string{"/x/history", "/quickstart", "/admin/intro", "/keys/", "/healthz", "/" + uuid} {
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+			req := httptest.NewRequest(method, path, nil)
+			req.Host = uuid + ".previews.example.com"
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			want := "preview " + method + " " + path
+			if rec.Code != http.StatusOK || rec.Body.String() != want {
+				t.Errorf("%s %s on the preview host = %d %q, want 200 %q", method, path, rec.Code, rec.Body.String(), want)
+			}
+		}
+	}
+
+	// The management API keeps its routes on other hosts.
+	req := httptest.NewRequest(http.MethodGet, "/x/history", nil)
+	req.Host = "previews.example.com"
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /x/history on the management host = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestLookupRouteFollowsTransitions(t *testing.T) {
+	s := useTestStore(t)
+	chdirTemp(t)
+	cache := previewAssets
+	t.Cleanup(func() { previewAssets = cache })
+	previewAssets = newAssetCache(1<<10, 1<<10)
+
+	uuid := strings.ToLower(ulid.Make().String())
+	t.Cleanup(func() { invalidateRoute(uuid) })
+	createTestDeployment(t, s, uuid)
+	if err := s.AssignPort(uuid, 3000, "http://127.0.0.1:3000"); err != nil {
+		t.Fatal(err)
+	}
+
+	// check looks the route up twice in the deployment's current state, with an asset cached.
+	check := func(want DeploymentState, wantProxy bool) {
+		t.Helper()
+		previewAssets.add(&cachedAsset{key: uuid + "|asset", uuid: uuid, status: http.StatusOK, body: []byte("asset")})
+		rt, err := lookupRoute(uuid)
+		if err != nil {
+			t.Fatalf("lookupRoute() in %s error = %v", want, err)
+		}
+		if rt.status != want || (rt.proxy != nil) != wantProxy {
+			t.Errorf("lookupRoute() in %s = status %s with proxy %v, want proxy %v", want, rt.status, rt.proxy != nil, wantProxy)
+		}
+		if again, _ := lookupRoute(uuid); again != rt {
+			t.Errorf("lookupRoute() in %s did not reuse the cached route", want)
+		}
+	}
+
+	check(StateQueued, false)
+	tests := []struct {
+		to        DeploymentState
+		wantProxy bool
+	}{
+		{to: StateStarting},
+		{to: StateRunning, wantProxy: true},
+		{to: StateStopped},
+		{to: StateQueued},
+		{to: StateCancelled},
+	}
+	for _, tt := range tests {
+		if err := transitionDeployment(uuid, tt.to, "test"); err != nil {
+			t.Fatalf("transitionDeployment(%s) error = %v", tt.to, err)
+		}
+		if _, ok := previewAssets.get(uuid + "|asset"); ok {
+			t.Errorf("moving to %s left the deployment's assets cached", tt.to)
+		}
+		check(tt.to, tt.wantProxy)
+	}
+
+	// Invalidating drops the route along with the deployment's cached assets.
+	rt, _ := lookupRoute(uuid)
+	invalidateRoute(uuid)
+	if again, _ := lookupRoute(uuid); again == rt {
+		t.Error("lookupRoute() returned the route cached before invalidateRoute()")
+	}
+	if _, ok := previewAssets.get(uuid + "|asset"); ok {
+		t.Error("invalidateRoute() left the deployment's assets cached")
+	}
+}
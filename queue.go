@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mintlify-previewer-backend/log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	buildWorkers = getEnvInt("BUILD_WORKERS", 2)
+
+	// previewSlots bounds the number of dev servers running at once.
+	previewSlots = newSlotPool(getEnvInt("MAX_RUNNING_PREVIEWS", 20))
+
+	// queueSignal wakes an idle worker when a deployment is enqueued.
+	queueSignal = make(chan struct{}, 1)
+
+	// workersStopped keeps workers from claiming new deployments during shutdown.
+	workersStopped atomic.Bool
+
+	// Time limits of the individual stages of a deployment.
+	checkTimeout   = getEnvDuration("CHECK_TIMEOUT", 30*time.Second)
+	cloneTimeout   = getEnvDuration("CLONE_TIMEOUT", 5*time.Minute)
+	installTimeout = getEnvDuration("INSTALL_TIMEOUT", 10*time.Minute)
+	startupTimeout = getEnvDuration("STARTUP_TIMEOUT", 3*time.Minute)
+
+	// buildCancels holds the cancel functions of deployments between being claimed and running.
+	// buildCancelsMu also serialises claiming against cancelling, so a deployment can't slip between the two.
+	buildCancels   = make(map[string]context.CancelFunc)
+	buildCancelsMu sync.Mutex
+
+	errNotCancellable = errors.New("deployment is neither queued nor starting")
+	errStageTimedOut  = errors.New("timed out")
+	errStageCancelled = errors.New("cancelled")
+)
+
+// queuePollInterval is how often idle workers look for work they might have missed a signal for.
+const queuePollInterval = 5 * time.Second
+
+// slotPool is a counting semaphore whose capacity may be exceeded by re-adopted servers.
+type slotPool struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	used int
+	max  int // 0 means unlimited
+}
+
+func newSlotPool(max int) *slotPool {
+	p := &slotPool{max: max}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// acquire blocks until a slot is free.
+func (p *slotPool) acquire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.max > 0 && p.used >= p.max {
+		p.cond.Wait()
+	}
+	p.used++
+}
+
+// forceAcquire takes a slot even if the pool is full, for servers that are already running.
+func (p *slotPool) forceAcquire() {
+	p.mu.Lock()
+	p.used++
+	p.mu.Unlock()
+}
+
+func (p *slotPool) release() {
+	p.mu.Lock()
+	p.used--
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+// startDeploymentWorkers starts the workers that take queued deployments through clone and startup.
+func startDeploymentWorkers() {
+	if buildWorkers < 1 {
+		buildWorkers = 1
+	}
+	for i := 0; i < buildWorkers; i++ {
+		go runDeploymentWorker()
+	}
+	log.Infof("Started %d deployment workers, at most %d previews will run at once", buildWorkers, previewSlots.max)
+}
+
+// stopDeploymentWorkers makes workers exit instead of claiming more deployments.
+// Deployments already being built are left to finish or to be restored on the next start.
+func stopDeploymentWorkers() {
+	workersStopped.Store(true)
+}
+
+// notifyQueue wakes a worker to pick up newly queued deployments.
+func notifyQueue() {
+	select {
+	case queueSignal <- struct{}{}:
+	default:
+	}
+}
+
+func runDeploymentWorker() {
+	for {
+		// Only claim work once it can actually be started, so that waiting deployments stay queued
+		// and keep reporting their position.
+		previewSlots.acquire()
+		if workersStopped.Load() {
+			previewSlots.release()
+			return
+		}
+
+		ctx, dep, err := claimNextDeployment()
+		if err != nil || dep == nil {
+			previewSlots.release()
+			if err != nil {
+				log.Errorf("Failed to claim queued deployment: %v", err)
+			}
+			select {
+			case <-queueSignal:
+			case <-time.After(queuePollInterval):
+			}
+			continue
+		}
+
+		b, ok := buildDeployment(ctx, dep)
+		if !ok {
+			finishBuild(dep.UUID)
+			previewSlots.release()
+			continue
+		}
+
+		if dep.Mode == deploymentModeStatic {
+			if err := finishBuildRunning(ctx, dep.UUID); err != nil {
+				failBuild(ctx, dep.UUID, err)
+				finishBuild(dep.UUID)
+			}
+			previewSlots.release()
+			continue
+		}
+
+		go func() {
+			defer previewSlots.release()
+			startDevServer(ctx, dep.UUID, b.command, b.port, b.dir)
+		}()
+	}
+}
+
+// claimNextDeployment moves the oldest queued deployment to starting and returns it, or nil if the queue is empty.
+// The returned context is cancelled by cancelDeployment until finishBuild or finishBuildRunning is called.
+func claimNextDeployment() (context.Context, *Deployment, error) {
+	buildCancelsMu.Lock()
+	defer buildCancelsMu.Unlock()
+
+	dep, err := store.ClaimNextDeployment()
+	if err != nil || dep == nil {
+		return nil, nil, err
+	}
+	invalidateRoute(dep.UUID)
+
+	ctx, cancel := context.WithCancel(deploymentLogContext(deploymentContext(dep.UUID), dep.UUID))
+	buildCancels[dep.UUID] = cancel
+	return ctx, dep, nil
+}
+
+// finishBuild ends the cancellable part of a deployment that did not get to run, along with its trace.
+func finishBuild(uuid string) {
+	buildCancelsMu.Lock()
+	cancel, ok := buildCancels[uuid]
+	delete(buildCancels, uuid)
+	buildCancelsMu.Unlock()
+	if ok {
+		cancel()
+	}
+	endDeploymentSpan(uuid, nil)
+}
+
+// finishBuildRunning marks a deployment as running and ends its build in one step with respect to
+// cancelDeployment, which either still aborts the build or finds the deployment running and leaves
+// it to be stopped. It fails if the build was cancelled first.
+func finishBuildRunning(ctx context.Context, uuid string) error {
+	buildCancelsMu.Lock()
+	defer buildCancelsMu.Unlock()
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("build %w", errStageCancelled)
+	}
+	if err := transitionDeployment(uuid, StateRunning, ""); err != nil {
+		return err
+	}
+	if cancel, ok := buildCancels[uuid]; ok {
+		delete(buildCancels, uuid)
+		cancel()
+	}
+	endDeploymentSpan(uuid, nil)
+	return nil
+}
+
+// cancelDeployment takes a queued deployment out of the queue, or aborts the build of a starting one.
+// A build that is aborted marks itself as cancelled once its current stage has stopped.
+func cancelDeployment(uuid string) error {
+	buildCancelsMu.Lock()
+	defer buildCancelsMu.Unlock()
+
+	if cancel, ok := buildCancels[uuid]; ok {
+		log.Infof("Cancelling build of UUID %s", uuid)
+		cancel()
+		return nil
+	}
+
+	dep, err := store.GetDeployment(uuid)
+	if err != nil {
+		return err
+	}
+	if dep.Status != StateQueued {
+		return errNotCancellable
+	}
+	log.Infof("Removing UUID %s from the queue", uuid)
+	endDeploymentSpan(uuid, errStageCancelled)
+	return transitionDeployment(uuid, StateCancelled, "cancelled while queued")
+}
+
+// failBuild records why a deployment did not get to run. Errors caused by cancelDeployment mark it
+// as cancelled rather than failed.
+func failBuild(ctx context.Context, uuid string, err error) {
+	logger := log.FromContext(ctx)
+	status := StateFailed
+	if errors.Is(ctx.Err(), context.Canceled) {
+		status = StateCancelled
+		logger.Infof("Deployment %s cancelled", uuid)
+	} else {
+		logger.Errorf("Deployment %s failed: %v", uuid, err)
+	}
+	recordSpanError(trace.SpanFromContext(ctx), err)
+	if err2 := transitionDeployment(uuid, status, err.Error()); err2 != nil {
+		logger.Infof("Failed to update status for UUID %s: %v for error %+v", uuid, err2, err)
+	}
+}
+
+// runStage runs one deployment stage under its own time limit, in a span of the deployment's trace,
+// and records its duration.
+func runStage(ctx context.Context, stage string, timeout time.Duration, run func(context.Context) error) error {
+	start := time.Now()
+	stageCtx, span := startStageSpan(ctx, stage)
+	defer span.End()
+	stageCtx, cancel := context.WithTimeout(stageCtx, timeout)
+	defer cancel()
+
+	err := run(stageCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(stageCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("%s %w after %s", stageNames[stage], errStageTimedOut, timeout)
+	} else if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("%s %w", stageNames[stage], errStageCancelled)
+	}
+	observeStage(stage, start, err)
+	recordSpanError(span, err)
+	return err
+}
+
+// build is what a deployment needs to start its dev server. Static deployments are complete once built.
+type build struct {
+	dir     string // directory of the docs config in the checkout
+	port    int
+	command []string // dev server command
+}
+
+// buildDeployment runs the build stages of a claimed deployment: clone and checkout preparation, the
+// builder's toolchain, and the static build for static deployments.
+// It records the failure reason and returns false if any stage fails or ctx is cancelled.
+func buildDeployment(ctx context.Context, dep *Deployment) (build, bool) {
+	fail := func(err error) (build, bool) {
+		failBuild(ctx, dep.UUID, err)
+		return build{}, false
+	}
+
+	if err := validateGitHubURL(dep.GitHubURL); err != nil {
+		return fail(err)
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return fail(err)
+	}
+	deploymentDir := filepath.Join(dir, ".repos", dep.UUID)
+
+	// Check if the deployment directory exists and is not empty (except for Git files)
+	if isEmptyOrOnlyGitFiles(deploymentDir) {
+		// Drop whatever an interrupted clone left behind, git refuses to clone into it.
+		if err := os.RemoveAll(deploymentDir); err != nil {
+			return fail(err)
+		}
+		_, repoURL := extractPRID(dep.GitHubURL)
+		err = runStage(ctx, stageClone, cloneTimeout, func(ctx context.Context) error {
+			_, err := cloneRepo(ctx, repoURL, dep.Branch, deploymentDir)
+			return err
+		})
+		if err != nil {
+			// A clone cut short leaves a partial checkout that isEmptyOrOnlyGitFiles wouldn't recognise.
+			_ = os.RemoveAll(deploymentDir)
+			return fail(err)
+		}
+	}
+
+	builder, config, err := resolveBuilder(dep, deploymentDir)
+	if err != nil {
+		return fail(err)
+	}
+	serverDir, err := prepareDocsDir(deploymentDir, config)
+	if err != nil {
+		return fail(err)
+	}
+
+	var cli string
+	err = runStage(ctx, stageInstall, installTimeout, func(ctx context.Context) error {
+		var err error
+		cli, err = builder.Install(ctx, dep)
+		return err
+	})
+	if err != nil {
+		return fail(err)
+	}
+	if ctx.Err() != nil {
+		return fail(errors.New("build cancelled"))
+	}
+
+	if dep.Mode == deploymentModeStatic {
+		command, output := builder.BuildCommand(cli, config)
+		err := runStage(ctx, stageBuild, buildTimeout, func(ctx context.Context) error {
+			return buildStaticSite(ctx, dep.UUID, command, serverDir, output)
+		})
+		if err != nil {
+			return fail(err)
+		}
+		return build{dir: serverDir}, true
+	}
+
+	port := dep.port
+	if port == 0 {
+		if port, err = reservePort(dep.UUID); err != nil {
+			return fail(err)
+		}
+	}
+
+	return build{dir: serverDir, port: port, command: builder.DevCommand(cli, config, port)}, true
+}
+
+// enqueueDeployment puts a deployment back in the queue, keeping its original place.
+func enqueueDeployment(uuid, reason string) error {
+	err := transitionDeployment(uuid, StateQueued, reason)
+	notifyQueue()
+	return err
+}
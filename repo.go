@@ -2,16 +2,108 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"mintlify-previewer-backend/log"
+	"net/url"
+	"os"
 	"os/exec"
+	"regexp"
+	"strings"
 )
 
+var (
+	allowedGitSchemes = getEnvList("ALLOWED_GIT_SCHEMES", []string{"https"})
+	allowedGitHosts   = getEnvList("ALLOWED_GIT_HOSTS", []string{"github.com"})
+
+	// repoAllowlist holds "owner/repo" or "owner/*" patterns. An empty list allows every repository.
+	repoAllowlist = getEnvList("REPO_ALLOWLIST", nil)
+
+	errInvalidRepoURL = errors.New("invalid github_url")
+	errInvalidBranch  = errors.New("invalid branch")
+	errRepoNotAllowed = errors.New("repository is not allowed")
+
+	pullRequestPath = regexp.MustCompile(`^/[A-Za-z0-9_.-]+/[A-Za-z0-9_.-]+/pull/[0-9]+/?$`)
+)
+
+// validateGitHubURL checks that a pull request URL points at an allowed host and repository.
+// Errors wrap errInvalidRepoURL for malformed URLs and errRepoNotAllowed for policy violations.
+func validateGitHubURL(githubURL string) error {
+	parsedURL, err := url.Parse(githubURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidRepoURL, err)
+	}
+	if parsedURL.User != nil || parsedURL.RawQuery != "" || parsedURL.Fragment != "" {
+		return fmt.Errorf("%w: credentials, query strings and fragments are not supported", errInvalidRepoURL)
+	}
+	if !pullRequestPath.MatchString(parsedURL.Path) || strings.Contains(parsedURL.Path, "..") {
+		return fmt.Errorf("%w: expected a URL like https://github.com/owner/repository/pull/42", errInvalidRepoURL)
+	}
+	if !containsFold(allowedGitSchemes, parsedURL.Scheme) {
+		return fmt.Errorf("%w: scheme %q is not allowed", errRepoNotAllowed, parsedURL.Scheme)
+	}
+	if !containsFold(allowedGitHosts, parsedURL.Host) {
+		return fmt.Errorf("%w: host %q is not allowed", errRepoNotAllowed, parsedURL.Host)
+	}
+
+	name, err := repoFullName(githubURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidRepoURL, err)
+	}
+	if len(repoAllowlist) > 0 && !matchRepoPatterns(repoAllowlist, name) {
+		return fmt.Errorf("%w: %s", errRepoNotAllowed, name)
+	}
+	return nil
+}
+
+// validateBranch rejects branch names that git would refuse or could mistake for options.
+func validateBranch(branch string) error {
+	switch {
+	case branch == "":
+		return fmt.Errorf("%w: branch cannot be empty", errInvalidBranch)
+	case strings.HasPrefix(branch, "-"), strings.HasPrefix(branch, "/"), strings.HasSuffix(branch, "/"),
+		strings.HasSuffix(branch, "."), strings.HasSuffix(branch, ".lock"),
+		strings.Contains(branch, ".."), strings.Contains(branch, "@{"), strings.Contains(branch, "//"):
+		return fmt.Errorf("%w: %q is not a valid branch name", errInvalidBranch, branch)
+	}
+	for _, c := range branch {
+		if c <= ' ' || c == 0x7f || strings.ContainsRune("~^:?*[\\", c) {
+			return fmt.Errorf("%w: %q is not a valid branch name", errInvalidBranch, branch)
+		}
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitCommand returns a git command restricted to the allowed transport protocols.
+// Prompts are disabled so that private or missing repositories fail instead of hanging.
+func gitCommand(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	killProcessGroupOnCancel(cmd)
+	cmd.Env = append(os.Environ(),
+		"GIT_ALLOW_PROTOCOL="+strings.Join(allowedGitSchemes, ":"),
+		"GIT_TERMINAL_PROMPT=0",
+	)
+	return cmd
+}
+
 // checkRepoExists checks if the repository exists and is accessible
-func checkRepoExists(repoURL, branch string) error {
-	cmd := exec.Command("git", "ls-remote", "--heads", repoURL, branch)
+func checkRepoExists(ctx context.Context, repoURL, branch string) error {
+	cmd := gitCommand(ctx, "ls-remote", "--heads", "--", repoURL, branch)
 
 	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if err != nil {
 		return fmt.Errorf("repository check failed: %v, output: %s", err, string(output))
 	}
@@ -25,10 +117,11 @@ func checkRepoExists(repoURL, branch string) error {
 }
 
 // cloneRepo clones the repository
-func cloneRepo(repoURL, branch, dir string) (string, error) {
-	log.Info("About to clone repo. Repo url is " + repoURL)
+func cloneRepo(ctx context.Context, repoURL, branch, dir string) (string, error) {
+	logger := log.FromContext(ctx)
+	logger.Info("About to clone repo. Repo url is " + repoURL)
 
-	cmd := exec.Command("git", "clone", "--depth", "1", "--branch", branch, repoURL, dir)
+	cmd := gitCommand(ctx, "clone", "--depth", "1", "--branch", branch, "--", repoURL, dir)
 
 	// Create pipes for stdout and stderr
 	stdoutPipe, err := cmd.StdoutPipe()
@@ -52,22 +145,25 @@ func cloneRepo(repoURL, branch, dir string) (string, error) {
 	// Log stdout in real-time
 	go func() {
 		for stdoutScanner.Scan() {
-			log.Info(stdoutScanner.Text())
+			logger.Info(stdoutScanner.Text())
 		}
 	}()
 
 	// Log stderr in real-time
 	go func() {
 		for stderrScanner.Scan() {
-			log.Info(stderrScanner.Text())
+			logger.Info(stderrScanner.Text())
 		}
 	}()
 
 	// Wait for the command to finish
 	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return err.Error(), ctx.Err()
+		}
 		return err.Error(), fmt.Errorf("failed to clone repo %s on branch %s: %w", repoURL, branch, err)
 	}
 
-	log.Info("Repository cloned successfully")
+	logger.Info("Repository cloned successfully")
 	return "", nil
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateGitHubURL(t *testing.T) {
+	allowlist := repoAllowlist
+	t.Cleanup(func() { repoAllowlist = allowlist })
+	repoAllowlist = []string{"acme/*", "Other/Docs"}
+
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{url: "https://github.com/acme/docs/pull/42", want: nil},
+		{url: "https://GitHub.com/acme/docs/pull/42/", want: nil},
+		{url: "https://github.com/other/docs/pull/1", want: nil},
+		{url: "https://github.com/other/website/pull/1", want: errRepoNotAllowed},
+		{url: "http://github.com/acme/docs/pull/42", want: errRepoNotAllowed},
+		{url: "file://github.com/acme/docs/pull/42", want: errRepoNotAllowed},
+		{url: "ssh://github.com/acme/docs/pull/42", want: errRepoNotAllowed},
+		{url: "https://gitlab.com/acme/docs/pull/42", want: errRepoNotAllowed},
+		{url: "https://github.com.evil.example/acme/docs/pull/42", want: errRepoNotAllowed},
+		{url: "https://token@github.com/acme/docs/pull/42", want: errInvalidRepoURL},
+		{url: "https://github.com/acme/docs/pull/42?x=1", want: errInvalidRepoURL},
+		{url: "https://github.com/acme/docs/pull/42#files", want: errInvalidRepoURL},
+		{url: "https://github.com/acme/../pull/42", want: errInvalidRepoURL},
+		{url: "https://github.com/acme/..docs/pull/42", want: errInvalidRepoURL},
+		{url: "https://github.com/acme/docs", want: errInvalidRepoURL},
+		{url: "https://github.com/acme/docs/pull/abc", want: errInvalidRepoURL},
+		{url: "--upload-pack=touch /tmp/x", want: errInvalidRepoURL},
+		{url: "://github.com", want: errInvalidRepoURL},
+	}
+	for _, tt := range tests {
+		err := validateGitHubURL(tt.url)
+		if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("validateGitHubURL(%q) = %v, want %v", tt.url, err, tt.want)
+		}
+	}
+}
+
+func TestValidateBranch(t *testing.T) {
+	tests := []struct {
+		branch string
+		valid  bool
+	}{
+		{branch: "main", valid: true},
+		{branch: "feature/new-docs", valid: true},
+		{branch: "release-1.2", valid: true},
+		{branch: "", valid: false},
+		{branch: "-b", valid: false},
+		{branch: "--upload-pack=touch", valid: false},
+		{branch: "/main", valid: false},
+		{branch: "main/", valid: false},
+		{branch: "main.", valid: false},
+		{branch: "main.lock", valid: false},
+		{branch: "a..b", valid: false},
+		{branch: "../main", valid: false},
+		{branch: "main@{1}", valid: false},
+		{branch: "a//b", valid: false},
+		{branch: "has space", valid: false},
+		{branch: "tab\tbranch", valid: false},
+		{branch: "del\x7f", valid: false},
+		{branch: "main~1", valid: false},
+		{branch: "main^", valid: false},
+		{branch: "a:b", valid: false},
+		{branch: "what?", valid: false},
+		{branch: "glob*", valid: false},
+		{branch: "[x]", valid: false},
+		{branch: `back\slash`, valid: false},
+	}
+	for _, tt := range tests {
+		err := validateBranch(tt.branch)
+		if tt.valid != (err == nil) {
+			t.Errorf("validateBranch(%q) = %v, want valid %v", tt.branch, err, tt.valid)
+		}
+		if err != nil && !errors.Is(err, errInvalidBranch) {
+			t.Errorf("validateBranch(%q) = %v, want errInvalidBranch", tt.branch, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mintlify-previewer-backend/log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// deletedRetention is how long a deleted deployment can be restored before it is purged.
+	deletedRetention = getEnvDuration("DELETED_RETENTION", 7*24*time.Hour)
+	purgeInterval    = getEnvDuration("PURGE_INTERVAL", time.Hour)
+
+	errNotDeleted        = errors.New("deployment is not deleted")
+	errRetentionExpired  = errors.New("deployment was deleted too long ago to be restored")
+	errStillShuttingDown = errors.New("deployment is still being stopped, try again shortly")
+)
+
+// deleteDeployment soft-deletes a deployment: it cancels or stops the preview, removes the checkout
+// and marks the row as deleted. The row is kept until the retention window ends so that the
+// deployment can be restored.
+func deleteDeployment(uuid string) error {
+	err := cancelDeployment(uuid)
+	if errors.Is(err, errNotCancellable) {
+		// Deployments that have already ended have no server left to stop, static ones never had one.
+		if err = stopMintlifyServer(uuid); errors.Is(err, errServerNotFound) {
+			err = stopStaticSite(uuid)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := removeCheckout(uuid); err != nil {
+		return fmt.Errorf("failed to remove checkout: %w", err)
+	}
+	if err := store.SoftDeleteDeployment(uuid, time.Now()); err != nil {
+		return err
+	}
+	invalidateRoute(uuid)
+	log.Infof("Deployment %s deleted", uuid)
+	return nil
+}
+
+// restoreDeletedDeployment brings back a deployment deleted within the retention window by queueing it
+// for a fresh clone.
+func restoreDeletedDeployment(dep *Deployment) error {
+	if dep.DeletedAt == nil {
+		return errNotDeleted
+	}
+	if time.Since(*dep.DeletedAt) > deletedRetention {
+		return errRetentionExpired
+	}
+	// A cancelled build only settles once its current stage has stopped.
+	if dep.Status != StateStopped && dep.Status != StateFailed && dep.Status != StateCancelled {
+		return errStillShuttingDown
+	}
+
+	// Whatever an aborted clone wrote after the deletion would be mistaken for a checkout.
+	if err := removeCheckout(dep.UUID); err != nil {
+		return fmt.Errorf("failed to remove checkout: %w", err)
+	}
+	if err := store.UndeleteDeployment(dep.UUID); err != nil {
+		return err
+	}
+	if err := enqueueDeployment(dep.UUID, "restored after deletion"); err != nil {
+		return err
+	}
+	log.Infof("Deployment %s restored", dep.UUID)
+	return nil
+}
+
+// startPurgeJanitor periodically removes deployments whose retention window has ended.
+func startPurgeJanitor() {
+	go func() {
+		for {
+			purgeDeletedDeployments()
+			time.Sleep(purgeInterval)
+		}
+	}()
+}
+
+func purgeDeletedDeployments() {
+	uuids, err := store.ListDeletedDeployments(time.Now().Add(-deletedRetention))
+	if err != nil {
+		log.Errorf("Failed to list deleted deployments: %v", err)
+		return
+	}
+
+	for _, uuid := range uuids {
+		if err := removeCheckout(uuid); err != nil {
+			log.Errorf("Failed to remove checkout of UUID %s: %v", uuid, err)
+			continue
+		}
+		if err := store.DeleteDeployment(uuid); err != nil {
+			log.Errorf("Failed to purge UUID %s: %v", uuid, err)
+			continue
+		}
+		invalidateRoute(uuid)
+		log.Infof("Purged deployment %s", uuid)
+	}
+}
+
+// removeCheckout deletes the cloned repository of a deployment.
+func removeCheckout(uuid string) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(dir, ".repos", uuid))
+}
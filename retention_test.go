@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeleteAndRestoreStaticDeployment(t *testing.T) {
+	useTestStore(t)
+	chdirTemp(t)
+
+	const uuid = "01jc0000000000000000000000"
+	dep := &Deployment{UUID: uuid, GitHubURL: "https://github.com/acme/docs", Branch: "main", DocsPath: "index.html", Mode: deploymentModeStatic, Builder: builderStatic}
+	if err := store.CreateDeployment(dep); err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range []DeploymentState{StateStarting, StateRunning} {
+		if err := transitionDeployment(uuid, state, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	site, err := siteDir(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(site, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(site, "index.html"), []byte("<h1>docs</h1>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if rt, err := lookupRoute(uuid); err != nil || rt.site == "" {
+		t.Fatalf("lookupRoute() = %+v, %v, want the static site", rt, err)
+	}
+
+	if err := deleteDeployment(uuid); err != nil {
+		t.Fatalf("deleteDeployment() error = %v", err)
+	}
+	deleted, err := store.GetDeployment(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.Status != StateStopped || deleted.DeletedAt == nil {
+		t.Fatalf("after delete got status %s, deleted at %v, want stopped and deleted", deleted.Status, deleted.DeletedAt)
+	}
+	if hasStaticSite(uuid) {
+		t.Error("static site still on disk after delete")
+	}
+	if rt, err := lookupRoute(uuid); err != nil || rt.site != "" {
+		t.Errorf("lookupRoute() = %+v, %v, want no static site", rt, err)
+	}
+
+	if err := restoreDeletedDeployment(deleted); err != nil {
+		t.Fatalf("restoreDeletedDeployment() error = %v", err)
+	}
+	restored, err := store.GetDeployment(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Status != StateQueued || restored.DeletedAt != nil {
+		t.Errorf("after restore got status %s, deleted at %v, want queued and not deleted", restored.Status, restored.DeletedAt)
+	}
+}
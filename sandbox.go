@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mintlify-previewer-backend/log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// sandboxHelperArg makes the server binary act as the sandbox helper instead of starting the API.
+// The helper sets up the private mount namespace, drops privileges and then execs the dev server.
+const sandboxHelperArg = "__sandbox-exec"
+
+// sandboxConfig controls how dev servers are isolated from the previewer and from each other.
+type sandboxConfig struct {
+	uid, gid      int // first of uidCount sandbox UIDs and GIDs, -1 keeps the server's own credentials
+	uidCount      int
+	home          string
+	memoryMax     string
+	cpuMax        string
+	pidsMax       string
+	cgroupRoot    string
+	privateMounts bool
+}
+
+var (
+	sandboxSettings = loadSandboxConfig()
+
+	// sandboxEnvNames are the variables of the previewer's environment passed on to dev servers and
+	// builds, along with NODE_* and npm_config_* settings. Everything else, API keys, DATABASE_URL and
+	// PREVIEW_TOKEN_SECRET included, stays out of reach of repository code. MINTLIFY_SANDBOX_ENV adds
+	// names to the list.
+	sandboxEnvNames = append([]string{"PATH", "LANG", "LC_ALL", "LC_CTYPE", "TZ"}, getEnvList("MINTLIFY_SANDBOX_ENV", nil)...)
+
+	errSandboxUIDsExhausted = errors.New("no free UID left in the sandbox UID range")
+)
+
+func loadSandboxConfig() sandboxConfig {
+	cfg := sandboxConfig{
+		uid:           getEnvInt("MINTLIFY_UID", -1),
+		gid:           getEnvInt("MINTLIFY_GID", -1),
+		uidCount:      getEnvInt("MINTLIFY_UID_COUNT", 1000),
+		home:          getEnv("MINTLIFY_HOME", "./.sandbox-home"),
+		memoryMax:     getEnv("MINTLIFY_MEMORY_MAX", ""),
+		pidsMax:       getEnv("MINTLIFY_PIDS_MAX", ""),
+		cgroupRoot:    getEnv("MINTLIFY_CGROUP_ROOT", "/sys/fs/cgroup/mintlify-previewer"),
+		privateMounts: getEnvBool("MINTLIFY_PRIVATE_MOUNTS", false),
+	}
+	if cfg.uid >= 0 && cfg.gid < 0 {
+		cfg.gid = cfg.uid
+	}
+	if cfg.uidCount < 1 {
+		log.Warnf("Invalid value %d for MINTLIFY_UID_COUNT, using 1", cfg.uidCount)
+		cfg.uidCount = 1
+	}
+
+	// MINTLIFY_CPU_LIMIT is a number of CPUs, converted to a cgroup v2 "quota period" pair.
+	if v := os.Getenv("MINTLIFY_CPU_LIMIT"); v != "" {
+		cpus, err := strconv.ParseFloat(v, 64)
+		if err != nil || cpus <= 0 {
+			log.Warnf("Invalid value %q for MINTLIFY_CPU_LIMIT, CPU will not be limited", v)
+		} else {
+			cfg.cpuMax = fmt.Sprintf("%d 100000", int64(cpus*100000))
+		}
+	}
+
+	if home, err := filepath.Abs(cfg.home); err == nil {
+		cfg.home = home
+	}
+	return cfg
+}
+
+// hasLimits reports whether dev servers need a cgroup of their own.
+func (c sandboxConfig) hasLimits() bool {
+	return c.memoryMax != "" || c.cpuMax != "" || c.pidsMax != ""
+}
+
+// dropsPrivileges reports whether dev servers run under dedicated UIDs.
+func (c sandboxConfig) dropsPrivileges() bool {
+	return c.uid >= 0
+}
+
+// cgroupPath returns the cgroup the processes of a deployment are limited by.
+func (c sandboxConfig) cgroupPath(uuid string) string {
+	return filepath.Join(c.cgroupRoot, "mintlify-"+uuid)
+}
+
+// userHome returns the home directory of a sandbox UID.
+func (c sandboxConfig) userHome(uid int) string {
+	return filepath.Join(c.home, strconv.Itoa(uid))
+}
+
+// reserveSandboxUID returns the UID the processes of a deployment run under, assigning it a free one
+// from the MINTLIFY_UID_COUNT UIDs starting at MINTLIFY_UID on first use. Deployments keep their UID
+// until they are purged, so no two previews can write to each other's checkout, and the unique index
+// on deployments.sandbox_uid keeps concurrent reservations apart like it does for ports.
+func reserveSandboxUID(uuid string) (int, error) {
+	dep, err := store.GetDeployment(uuid)
+	if err != nil {
+		return 0, err
+	}
+	if dep.sandboxUID != 0 {
+		return dep.sandboxUID, nil
+	}
+
+	used, err := store.AssignedSandboxUIDs()
+	if err != nil {
+		return 0, err
+	}
+	cfg := sandboxSettings
+	for uid := cfg.uid; uid < cfg.uid+cfg.uidCount; uid++ {
+		if used[uid] {
+			continue
+		}
+
+		err := store.AssignSandboxUID(uuid, uid)
+		if err == nil {
+			// Whatever a purged deployment left in the home of the UID must not reach the next one.
+			if err := os.RemoveAll(cfg.userHome(uid)); err != nil {
+				return 0, fmt.Errorf("failed to clear sandbox home: %w", err)
+			}
+			return uid, nil
+		}
+		if errors.Is(err, errSandboxUIDTaken) {
+			continue
+		}
+		return 0, err
+	}
+
+	log.Warnf("Sandbox UID range %d-%d is exhausted", cfg.uid, cfg.uid+cfg.uidCount-1)
+	return 0, errSandboxUIDsExhausted
+}
+
+// sandboxEnv returns the environment sandboxed commands run with: the allowlisted part of the
+// previewer's own, with HOME pointing at home.
+func sandboxEnv(home string) []string {
+	env := []string{"HOME=" + home}
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if slices.Contains(sandboxEnvNames, name) || strings.HasPrefix(name, "NODE_") ||
+			strings.HasPrefix(strings.ToLower(name), "npm_config_") {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
@@ -0,0 +1,301 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mintlify-previewer-backend/log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sandbox holds the per-process resources created for a dev server.
+type sandbox struct {
+	cgroupPath string
+	cgroupDir  *os.File
+}
+
+// newSandboxedCommand prepares a command running in its own process group with the sandbox
+// environment, optionally under the deployment's own UID, inside a cgroup with resource limits and
+// in a private mount namespace.
+func newSandboxedCommand(uuid, dir, name string, args ...string) (*exec.Cmd, *sandbox, error) {
+	cfg := sandboxSettings
+	sb := &sandbox{}
+	attr := &syscall.SysProcAttr{Setpgid: true}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get working directory: %w", err)
+	}
+	checkout := filepath.Join(wd, ".repos", uuid)
+
+	uid, gid, home := -1, -1, cfg.home
+	if cfg.dropsPrivileges() {
+		if uid, err = reserveSandboxUID(uuid); err != nil {
+			return nil, nil, fmt.Errorf("failed to reserve sandbox UID: %w", err)
+		}
+		gid = cfg.gid + uid - cfg.uid
+		home = cfg.userHome(uid)
+	}
+	if err := os.MkdirAll(home, 0o755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create sandbox home: %w", err)
+	}
+
+	var cmd *exec.Cmd
+	env := sandboxEnv(home)
+	if cfg.privateMounts {
+		self, err := os.Executable()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to locate sandbox helper: %w", err)
+		}
+
+		// Hide the other checkouts and the previewer's own state from the dev server.
+		helperArgs := []string{sandboxHelperArg, checkout, dir, filepath.Join(wd, ".repos"), filepath.Join(wd, ".sqlite"), "--", name}
+		cmd = exec.Command(self, append(helperArgs, args...)...)
+		attr.Unshareflags = syscall.CLONE_NEWNS
+		if cfg.dropsPrivileges() {
+			env = append(env, "SANDBOX_UID="+strconv.Itoa(uid), "SANDBOX_GID="+strconv.Itoa(gid))
+		}
+	} else {
+		cmd = exec.Command(name, args...)
+		cmd.Dir = dir
+		if cfg.dropsPrivileges() {
+			attr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: []uint32{}}
+		}
+	}
+
+	if cfg.dropsPrivileges() {
+		for _, path := range []string{checkout, home} {
+			if err := chownTree(path, uid, gid); err != nil {
+				return nil, nil, fmt.Errorf("failed to hand %s to the sandbox user: %w", path, err)
+			}
+			// Other previews run under other UIDs and have no business in here.
+			if err := os.Chmod(path, 0o700); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if cfg.hasLimits() {
+		if err := sb.createCgroup(cfg, uuid); err != nil {
+			return nil, nil, fmt.Errorf("failed to create cgroup: %w", err)
+		}
+		attr.UseCgroupFD = true
+		attr.CgroupFD = int(sb.cgroupDir.Fd())
+	}
+
+	cmd.Env = env
+	cmd.SysProcAttr = attr
+	return cmd, sb, nil
+}
+
+func (sb *sandbox) createCgroup(cfg sandboxConfig, uuid string) error {
+	if err := os.MkdirAll(cfg.cgroupRoot, 0o755); err != nil {
+		return err
+	}
+
+	var controllers []string
+	if cfg.memoryMax != "" {
+		controllers = append(controllers, "+memory")
+	}
+	if cfg.cpuMax != "" {
+		controllers = append(controllers, "+cpu")
+	}
+	if cfg.pidsMax != "" {
+		controllers = append(controllers, "+pids")
+	}
+	if err := os.WriteFile(filepath.Join(cfg.cgroupRoot, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0o644); err != nil {
+		return fmt.Errorf("failed to enable controllers in %s: %w", cfg.cgroupRoot, err)
+	}
+
+	sb.cgroupPath = cfg.cgroupPath(uuid)
+	if err := os.Mkdir(sb.cgroupPath, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+
+	limits := map[string]string{
+		"memory.max": cfg.memoryMax,
+		"cpu.max":    cfg.cpuMax,
+		"pids.max":   cfg.pidsMax,
+	}
+	for file, value := range limits {
+		if value == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(sb.cgroupPath, file), []byte(value), 0o644); err != nil {
+			return fmt.Errorf("failed to set %s: %w", file, err)
+		}
+	}
+	if cfg.memoryMax != "" {
+		// Without this the limit is only enforced once swap is exhausted too.
+		_ = os.WriteFile(filepath.Join(sb.cgroupPath, "memory.swap.max"), []byte("0"), 0o644)
+	}
+
+	dir, err := os.Open(sb.cgroupPath)
+	if err != nil {
+		return err
+	}
+	sb.cgroupDir = dir
+	return nil
+}
+
+// adoptSandbox returns the sandbox of a dev server started before the previewer restarted, so that
+// its limits can still be checked and its cgroup removed once it exits.
+func adoptSandbox(uuid string) *sandbox {
+	sb := &sandbox{}
+	// The cgroup is looked for even if limits have since been turned off, it would be left behind otherwise.
+	path := sandboxSettings.cgroupPath(uuid)
+	if _, err := os.Stat(path); err == nil {
+		sb.cgroupPath = path
+	}
+	return sb
+}
+
+// started releases resources only needed to spawn the process.
+func (sb *sandbox) started() {
+	if sb.cgroupDir != nil {
+		_ = sb.cgroupDir.Close()
+		sb.cgroupDir = nil
+	}
+}
+
+// violation describes the resource limit the process group ran into, if any.
+func (sb *sandbox) violation() string {
+	if sb.cgroupPath == "" {
+		return ""
+	}
+	if readCgroupCounter(filepath.Join(sb.cgroupPath, "memory.events"), "oom_kill") > 0 {
+		return fmt.Sprintf("memory limit of %s exceeded", sandboxSettings.memoryMax)
+	}
+	if readCgroupCounter(filepath.Join(sb.cgroupPath, "pids.events"), "max") > 0 {
+		return fmt.Sprintf("process limit of %s exceeded", sandboxSettings.pidsMax)
+	}
+	return ""
+}
+
+// cleanup kills anything left in the cgroup and removes it.
+func (sb *sandbox) cleanup() {
+	sb.started()
+	if sb.cgroupPath == "" {
+		return
+	}
+
+	_ = os.WriteFile(filepath.Join(sb.cgroupPath, "cgroup.kill"), []byte("1"), 0o644)
+	for i := 0; i < 10; i++ {
+		err := syscall.Rmdir(sb.cgroupPath)
+		if err == nil || errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Warnf("Failed to remove cgroup %s", sb.cgroupPath)
+}
+
+func readCgroupCounter(path, name string) int64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		field, value, ok := strings.Cut(scanner.Text(), " ")
+		if ok && field == name {
+			n, _ := strconv.ParseInt(value, 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+func chownTree(root string, uid, gid int) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+// runSandboxHelper runs inside the freshly unshared mount namespace of a dev server.
+// Arguments are: checkout dir, working dir, directories to hide, "--", then the command to exec.
+func runSandboxHelper(args []string) {
+	sep := slices.Index(args, "--")
+	if sep < 2 || sep == len(args)-1 {
+		log.Fatal("invalid sandbox helper arguments")
+	}
+	checkout, workDir, hidden, command := args[0], args[1], args[2:sep], args[sep+1:]
+
+	if err := isolateMounts(checkout, hidden); err != nil {
+		log.Fatal("failed to isolate mounts: ", err)
+	}
+
+	if v := os.Getenv("SANDBOX_UID"); v != "" {
+		uid, _ := strconv.Atoi(v)
+		gid, _ := strconv.Atoi(os.Getenv("SANDBOX_GID"))
+		if err := syscall.Setgroups([]int{}); err != nil {
+			log.Fatal("failed to clear supplementary groups: ", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			log.Fatal("failed to set gid: ", err)
+		}
+		if err := syscall.Setuid(uid); err != nil {
+			log.Fatal("failed to set uid: ", err)
+		}
+	}
+
+	if err := os.Chdir(workDir); err != nil {
+		log.Fatal("failed to enter working directory: ", err)
+	}
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		log.Fatal("failed to find command: ", err)
+	}
+
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, "SANDBOX_")
+	})
+	log.Fatal(syscall.Exec(path, command, env))
+}
+
+// isolateMounts covers the hidden directories with empty tmpfs mounts and bind-mounts the checkout back into place.
+func isolateMounts(checkout string, hidden []string) error {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+
+	// Keep a handle on the checkout, it is unreachable by path once its parent is covered.
+	f, err := os.Open(checkout)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, dir := range hidden {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		if err := syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755"); err != nil {
+			return fmt.Errorf("failed to hide %s: %w", dir, err)
+		}
+	}
+
+	if err := os.MkdirAll(checkout, 0o755); err != nil {
+		return err
+	}
+	source := "/proc/self/fd/" + strconv.Itoa(int(f.Fd()))
+	if err := syscall.Mount(source, checkout, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to expose checkout: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdoptSandbox(t *testing.T) {
+	settings := sandboxSettings
+	t.Cleanup(func() { sandboxSettings = settings })
+	sandboxSettings = sandboxConfig{uid: -1, gid: -1, cgroupRoot: t.TempDir(), memoryMax: "512M"}
+
+	const uuid = "01jc0000000000000000000000"
+	if sb := adoptSandbox(uuid); sb.cgroupPath != "" {
+		t.Errorf("adoptSandbox() without a cgroup = %q, want none", sb.cgroupPath)
+	}
+
+	// A server that ran out of memory while the previewer was down.
+	cgroup := filepath.Join(sandboxSettings.cgroupRoot, "mintlify-"+uuid)
+	if err := os.Mkdir(cgroup, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cgroup, "memory.events"), []byte("oom 1\noom_kill 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sb := adoptSandbox(uuid)
+	if sb.cgroupPath != cgroup {
+		t.Fatalf("adoptSandbox() = %q, want %q", sb.cgroupPath, cgroup)
+	}
+	if reason := sb.violation(); !strings.Contains(reason, "memory limit of 512M") {
+		t.Errorf("violation() = %q, want the memory limit", reason)
+	}
+}
@@ -0,0 +1,41 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mintlify-previewer-backend/log"
+	"os"
+	"os/exec"
+)
+
+// sandbox is a no-op outside Linux, where cgroups and mount namespaces are unavailable.
+type sandbox struct{}
+
+func newSandboxedCommand(uuid, dir, name string, args ...string) (*exec.Cmd, *sandbox, error) {
+	cfg := sandboxSettings
+	if cfg.dropsPrivileges() || cfg.hasLimits() || cfg.privateMounts {
+		return nil, nil, errors.New("sandboxing dev servers is only supported on Linux")
+	}
+
+	if err := os.MkdirAll(cfg.home, 0o755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create sandbox home: %w", err)
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = sandboxEnv(cfg.home)
+	return cmd, &sandbox{}, nil
+}
+
+func adoptSandbox(uuid string) *sandbox { return &sandbox{} }
+
+func (sb *sandbox) started() {}
+
+func (sb *sandbox) violation() string { return "" }
+
+func (sb *sandbox) cleanup() {}
+
+func runSandboxHelper(args []string) {
+	log.Fatal("the sandbox helper is only supported on Linux")
+}
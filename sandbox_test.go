@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSandboxEnvironment(t *testing.T) {
+	settings := sandboxSettings
+	t.Cleanup(func() { sandboxSettings = settings })
+	sandboxSettings = sandboxConfig{uid: -1, gid: -1, home: t.TempDir()}
+
+	t.Setenv("ADMIN_API_KEY", "mpk_admin")
+	t.Setenv("DATABASE_URL", "postgres://previewer:secret@db/previewer")
+	t.Setenv("PREVIEW_TOKEN_SECRET", "token secret")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "aws secret")
+	t.Setenv("NODE_OPTIONS", "--max-old-space-size=2048")
+	t.Setenv("npm_config_registry", "https://registry.example")
+	t.Setenv("LANG", "C.UTF-8")
+
+	cmd, sb, err := newSandboxedCommand("01jc0000000000000000000000", t.TempDir(), "env")
+	if err != nil {
+		t.Fatalf("newSandboxedCommand() error = %v", err)
+	}
+	defer sb.cleanup()
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("env failed: %v", err)
+	}
+
+	env := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		name, value, _ := strings.Cut(line, "=")
+		env[name] = value
+	}
+	for _, name := range []string{"ADMIN_API_KEY", "DATABASE_URL", "PREVIEW_TOKEN_SECRET", "AWS_SECRET_ACCESS_KEY"} {
+		if _, ok := env[name]; ok {
+			t.Errorf("%s leaked into the sandbox", name)
+		}
+	}
+	want := map[string]string{
+		"HOME":                sandboxSettings.home,
+		"NODE_OPTIONS":        "--max-old-space-size=2048",
+		"npm_config_registry": "https://registry.example",
+		"LANG":                "C.UTF-8",
+	}
+	for name, value := range want {
+		if env[name] != value {
+			t.Errorf("%s = %q, want %q", name, env[name], value)
+		}
+	}
+	if env["PATH"] == "" {
+		t.Error("PATH is missing from the sandbox")
+	}
+}
+
+func TestReserveSandboxUID(t *testing.T) {
+	s := useTestStore(t)
+	settings := sandboxSettings
+	t.Cleanup(func() { sandboxSettings = settings })
+	sandboxSettings = sandboxConfig{uid: 20000, gid: 20000, uidCount: 2, home: t.TempDir()}
+
+	for _, uuid := range []string{"dep-a", "dep-b", "dep-c"} {
+		createTestDeployment(t, s, uuid)
+	}
+
+	// A purged deployment's files in the home of its UID are gone before the UID is handed out again.
+	leftover := filepath.Join(sandboxSettings.userHome(20000), ".npmrc")
+	if err := os.MkdirAll(filepath.Dir(leftover), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(leftover, []byte("registry=https://evil.example"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		uuid    string
+		want    int
+		wantErr error
+	}{
+		{uuid: "dep-a", want: 20000},
+		{uuid: "dep-b", want: 20001},
+		{uuid: "dep-a", want: 20000},
+		{uuid: "dep-c", wantErr: errSandboxUIDsExhausted},
+	}
+	for _, tt := range tests {
+		uid, err := reserveSandboxUID(tt.uuid)
+		if !errors.Is(err, tt.wantErr) || uid != tt.want {
+			t.Fatalf("reserveSandboxUID(%s) = %d, %v, want %d, %v", tt.uuid, uid, err, tt.want, tt.wantErr)
+		}
+	}
+	if _, err := os.Stat(leftover); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("leftover file in the sandbox home survived: %v", err)
+	}
+
+	// Purging a deployment gives its UID back.
+	if err := s.DeleteDeployment("dep-b"); err != nil {
+		t.Fatal(err)
+	}
+	if uid, err := reserveSandboxUID("dep-c"); err != nil || uid != 20001 {
+		t.Errorf("reserveSandboxUID(dep-c) after a purge = %d, %v, want 20001", uid, err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// DeploymentState is the lifecycle state of a deployment.
+type DeploymentState string
+
+const (
+	// stateNew is the state before a deployment exists, the origin of its first transition.
+	stateNew DeploymentState = ""
+
+	StateQueued    DeploymentState = "queued"
+	StateStarting  DeploymentState = "starting"
+	StateRunning   DeploymentState = "running"
+	StateStopped   DeploymentState = "stopped"
+	StateFailed    DeploymentState = "failed"
+	StateCancelled DeploymentState = "cancelled"
+)
+
+// deploymentTransitions lists the states each state may move to.
+var deploymentTransitions = map[DeploymentState][]DeploymentState{
+	stateNew: {StateQueued},
+	// Queued deployments are claimed by a worker or cancelled before they get there.
+	StateQueued: {StateStarting, StateCancelled},
+	// Starting deployments are built, then run; restoreDeployments re-queues or re-adopts them after a restart.
+	StateStarting: {StateRunning, StateFailed, StateCancelled, StateQueued},
+	// Running deployments go back to starting when they are stopped for a restart of the previewer.
+	StateRunning: {StateStopped, StateFailed, StateStarting, StateQueued},
+	// Deployments that have ended are only queued again when they are restored after a deletion.
+	StateStopped:   {StateQueued},
+	StateFailed:    {StateQueued},
+	StateCancelled: {StateQueued},
+}
+
+var errInvalidTransition = errors.New("invalid deployment state transition")
+
+// DeploymentEvent is a recorded state transition of a deployment.
+type DeploymentEvent struct {
+	From   DeploymentState `json:"from"`
+	To     DeploymentState `json:"to"`
+	Reason string          `json:"reason,omitempty"`
+	At     time.Time       `json:"at"`
+}
+
+// canTransition reports whether a deployment may move from one state to another.
+func canTransition(from, to DeploymentState) bool {
+	return slices.Contains(deploymentTransitions[from], to)
+}
+
+// transitionDeployment moves a deployment to a new state through the store and drops its cached
+// proxy route so that the next request sees the new state. Invalid transitions are rejected with
+// an error wrapping errInvalidTransition. The reason is kept as the deployment's error when it fails
+// or is cancelled; those and stopped deployments give their port back to the pool.
+func transitionDeployment(uuid string, to DeploymentState, reason string) error {
+	if err := store.TransitionDeployment(uuid, to, reason); err != nil {
+		return err
+	}
+	invalidateRoute(uuid)
+	observeDeploymentState(to)
+	return nil
+}
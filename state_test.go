@@ -0,0 +1,31 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	states := []DeploymentState{stateNew, StateQueued, StateStarting, StateRunning, StateStopped, StateFailed, StateCancelled, "unknown"}
+	tests := []struct {
+		from    DeploymentState
+		allowed []DeploymentState
+	}{
+		{from: stateNew, allowed: []DeploymentState{StateQueued}},
+		{from: StateQueued, allowed: []DeploymentState{StateStarting, StateCancelled}},
+		{from: StateStarting, allowed: []DeploymentState{StateRunning, StateFailed, StateCancelled, StateQueued}},
+		{from: StateRunning, allowed: []DeploymentState{StateStopped, StateFailed, StateStarting, StateQueued}},
+		{from: StateStopped, allowed: []DeploymentState{StateQueued}},
+		{from: StateFailed, allowed: []DeploymentState{StateQueued}},
+		{from: StateCancelled, allowed: []DeploymentState{StateQueued}},
+		{from: "unknown", allowed: nil},
+	}
+	for _, tt := range tests {
+		for _, to := range states {
+			want := slices.Contains(tt.allowed, to)
+			if got := canTransition(tt.from, to); got != want {
+				t.Errorf("canTransition(%q, %q) = %v, want %v", tt.from, to, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mintlify-previewer-backend/log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Deployment modes. Dev previews proxy to a resident dev server, static previews are built once
+// and served by the previewer itself.
+const (
+	deploymentModeDev    = "dev"
+	deploymentModeStatic = "static"
+)
+
+// siteDirName is the directory of a deployment's checkout that holds its static build.
+const siteDirName = ".preview-site"
+
+var (
+	buildTimeout = getEnvDuration("BUILD_TIMEOUT", 10*time.Minute)
+
+	errInvalidMode = fmt.Errorf("mode must be %s or %s", deploymentModeDev, deploymentModeStatic)
+
+	// hashedAssetPattern matches file names carrying a content hash, which never change in place.
+	hashedAssetPattern = regexp.MustCompile(`[.-][0-9a-fA-F]{8,}\.[0-9a-zA-Z]+$`)
+)
+
+func init() {
+	// Types missing from Go's built-in table that static exports commonly contain.
+	for ext, typ := range map[string]string{
+		".ico":         "image/x-icon",
+		".map":         "application/json",
+		".otf":         "font/otf",
+		".ttf":         "font/ttf",
+		".txt":         "text/plain; charset=utf-8",
+		".webmanifest": "application/manifest+json",
+		".woff":        "font/woff",
+		".woff2":       "font/woff2",
+	} {
+		_ = mime.AddExtensionType(ext, typ)
+	}
+}
+
+// validateMode checks the mode requested for a deployment; empty selects dev.
+func validateMode(mode string) error {
+	switch mode {
+	case "", deploymentModeDev, deploymentModeStatic:
+		return nil
+	}
+	return errInvalidMode
+}
+
+// siteDir returns where the static build of a deployment is kept.
+func siteDir(uuid string) (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, ".repos", uuid, siteDirName), nil
+}
+
+// stopStaticSite takes a running static deployment offline, evicting whatever of its site is cached.
+// Other deployments are left as they are.
+func stopStaticSite(uuid string) error {
+	dep, err := store.GetDeployment(uuid)
+	if err != nil {
+		return err
+	}
+	if dep.Mode != deploymentModeStatic || dep.Status != StateRunning {
+		return nil
+	}
+	log.Infof("Static site for UUID %s stopped", uuid)
+	return transitionDeployment(uuid, StateStopped, "stopped on request")
+}
+
+// hasStaticSite reports whether a deployment's static build is in place.
+func hasStaticSite(uuid string) bool {
+	dir, err := siteDir(uuid)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(dir, "index.html"))
+	return err == nil
+}
+
+// buildStaticSite runs the static build of a deployment in its sandbox and moves its output, relative to
+// docsDir, to siteDir. Without a command, the output directory is copied as it is.
+func buildStaticSite(ctx context.Context, uuid string, command []string, docsDir, output string) error {
+	site, err := siteDir(uuid)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(site); err != nil {
+		return err
+	}
+	if command == nil {
+		if err := copySite(filepath.Join(docsDir, output), site); err != nil {
+			return fmt.Errorf("failed to copy site: %w", err)
+		}
+		if !hasStaticSite(uuid) {
+			return errors.New("site has no index.html")
+		}
+		return nil
+	}
+
+	cmd, sb, err := newSandboxedCommand(uuid, docsDir, command[0], command[1:]...)
+	if err != nil {
+		return fmt.Errorf("failed to prepare build sandbox: %w", err)
+	}
+	defer sb.cleanup()
+
+	out := log.FromContext(ctx).WithFields(log.Fields{"stage": stageBuild}).Writer()
+	defer out.Close()
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start build: %w", err)
+	}
+	sb.started()
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- cmd.Wait() }()
+	select {
+	case err = <-waitErr:
+	case <-ctx.Done():
+		_ = signalProcessGroup(cmd.Process.Pid, syscall.SIGKILL)
+		<-waitErr
+		return ctx.Err()
+	}
+	if err != nil {
+		if reason := sb.violation(); reason != "" {
+			return fmt.Errorf("build failed: %s", reason)
+		}
+		return fmt.Errorf("build failed: %w", err)
+	}
+
+	if err := os.Rename(filepath.Join(docsDir, output), site); err != nil {
+		return fmt.Errorf("build output not found in %s: %w", output, err)
+	}
+	if !hasStaticSite(uuid) {
+		return errors.New("build output has no index.html")
+	}
+	return nil
+}
+
+// copySite copies the files of a prebuilt site to dst. Hidden files and directories, the checkout's
+// .git and siteDirName among them, are left out, and symlinks are copied as the files they point to.
+func copySite(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+
+		// Symlinks escaping the checkout have been removed by prepareDocsDir.
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// serveStaticSite serves the static build in root. Paths are tried as a file, a directory index
+// and an .html page; unknown paths without an extension fall back to the root document, which
+// routes them client-side.
+func serveStaticSite(w http.ResponseWriter, r *http.Request, root string) {
+	name := path.Clean("/" + r.URL.Path)
+	file, ok := resolveStaticFile(root, name)
+	if !ok {
+		if path.Ext(name) != "" {
+			http.NotFound(w, r)
+			return
+		}
+		if file, ok = resolveStaticFile(root, "/index.html"); !ok {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", staticCacheControl(file))
+	http.ServeContent(w, r, file, info.ModTime(), f)
+}
+
+// resolveStaticFile maps a request path to a regular file under root. Symlinks leading out of root,
+// which a repository could plant in its build output, are not followed.
+func resolveStaticFile(root, name string) (string, bool) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", false
+	}
+	candidates := []string{name, path.Join(name, "index.html")}
+	if path.Ext(name) == "" && name != "/" {
+		candidates = append(candidates, name+".html")
+	}
+	for _, c := range candidates {
+		file, err := filepath.EvalSymlinks(filepath.Join(realRoot, filepath.FromSlash(c)))
+		if err != nil || !strings.HasPrefix(file, realRoot+string(filepath.Separator)) {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil && info.Mode().IsRegular() {
+			return file, true
+		}
+	}
+	return "", false
+}
+
+// staticCacheControl lets browsers keep content-hashed assets forever and revalidate pages.
+func staticCacheControl(file string) string {
+	switch {
+	case strings.HasSuffix(file, ".html"):
+		return "no-cache"
+	case strings.Contains(filepath.ToSlash(file), "/_next/static/"), hashedAssetPattern.MatchString(filepath.Base(file)):
+		return "public, max-age=31536000, immutable"
+	default:
+		return "public, max-age=300"
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mintlify-previewer-backend/log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Store persists deployments, their history and API keys.
+type Store interface {
+	// CreateDeployment stores a new queued deployment and records its creation. A deployment created with
+	// a port holds it from the start, CreateDeployment returns errPortTaken if another deployment does.
+	CreateDeployment(dep *Deployment) error
+	// DeleteDeployment removes a deployment along with its history.
+	DeleteDeployment(uuid string) error
+	// GetDeployment returns a deployment, including a soft-deleted one, or errNotFound.
+	GetDeployment(uuid string) (*Deployment, error)
+	// ListDeployments returns the deployments in any of the given states that haven't been deleted.
+	ListDeployments(states ...DeploymentState) ([]*Deployment, error)
+	// SoftDeleteDeployment marks a deployment as deleted at the given time.
+	SoftDeleteDeployment(uuid string, at time.Time) error
+	// UndeleteDeployment clears the deletion mark of a deployment.
+	UndeleteDeployment(uuid string) error
+	// ListDeletedDeployments returns the IDs of deployments deleted before a given time.
+	ListDeletedDeployments(before time.Time) ([]string, error)
+
+	// ClaimNextDeployment moves the oldest queued, undeleted deployment to starting and returns it, or nil if the queue is empty.
+	ClaimNextDeployment() (*Deployment, error)
+	// TransitionDeployment moves a deployment to a new state and records the transition with its reason.
+	// It returns an error wrapping errInvalidTransition if the current state doesn't allow it.
+	TransitionDeployment(uuid string, to DeploymentState, reason string) error
+	// DeploymentHistory returns the transitions of a deployment, oldest first.
+	DeploymentHistory(uuid string) ([]DeploymentEvent, error)
+	// QueuePosition returns the 1-based position of a queued deployment.
+	QueuePosition(uuid string) (int, error)
+
+	// SetDeploymentProcess records the dev server process of a deployment.
+	SetDeploymentProcess(uuid string, pid int, startTime int64) error
+	// ClearDeploymentProcess forgets the dev server process of a deployment if it is still pid.
+	ClearDeploymentProcess(uuid string, pid int) error
+	// AssignPort gives a port to a deployment, or returns errPortTaken if another deployment holds it.
+	AssignPort(uuid string, port int, upstreamURL string) error
+	// AssignedPorts returns the ports currently held by deployments.
+	AssignedPorts() (map[int]bool, error)
+	// AssignSandboxUID gives a sandbox UID to a deployment, or returns errSandboxUIDTaken if another deployment holds it.
+	AssignSandboxUID(uuid string, uid int) error
+	// AssignedSandboxUIDs returns the sandbox UIDs held by deployments, deleted ones included.
+	AssignedSandboxUIDs() (map[int]bool, error)
+
+	// CreateAPIKey stores a new key under the hash of its plaintext value.
+	CreateAPIKey(k *APIKey, keyHash string) error
+	// PutAPIKey stores a key, replacing the hash and scopes of an existing key with the same ID and reinstating it.
+	PutAPIKey(k *APIKey, keyHash string) error
+	// ListAPIKeys returns the active keys.
+	ListAPIKeys() ([]APIKey, error)
+	// FindAPIKey returns the active key with the given hash, or errNotFound.
+	FindAPIKey(keyHash string) (*APIKey, error)
+	// RevokeAPIKey revokes an active key and reports whether it existed.
+	RevokeAPIKey(id string) (bool, error)
+	// TouchAPIKey records that a key has just been used.
+	TouchAPIKey(id string) error
+	// CountAPIKeys returns the number of active keys.
+	CountAPIKeys() (int, error)
+
+	// CheckWritable verifies that the database accepts writes, without changing anything.
+	CheckWritable() error
+	// MigrationStatus returns the applied schema version, the latest one available and whether
+	// the last migration failed halfway.
+	MigrationStatus() (current, latest uint, dirty bool, err error)
+
+	Close() error
+}
+
+const defaultSQLitePath = "./.sqlite/deployments.db"
+
+var (
+	store Store
+
+	errNotFound        = errors.New("not found")
+	errPortTaken       = errors.New("port is taken by another deployment")
+	errSandboxUIDTaken = errors.New("sandbox UID is taken by another deployment")
+)
+
+// initDB opens the store selected by DATABASE_URL and applies its migrations.
+// Without DATABASE_URL deployments are kept in a local SQLite database.
+func initDB() {
+	var err error
+	store, err = openStore(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		log.Fatal("failed to open database: ", err)
+	}
+	log.Info("Migrations applied successfully!")
+}
+
+func openStore(databaseURL string) (Store, error) {
+	switch {
+	case databaseURL == "":
+		return openSQLiteStore(defaultSQLitePath)
+	case strings.HasPrefix(databaseURL, "sqlite://"):
+		return openSQLiteStore(strings.TrimPrefix(databaseURL, "sqlite://"))
+	case strings.HasPrefix(databaseURL, "postgres://"), strings.HasPrefix(databaseURL, "postgresql://"):
+		return openPostgresStore(databaseURL)
+	default:
+		return nil, fmt.Errorf("unsupported DATABASE_URL scheme, expected sqlite:// or postgres://")
+	}
+}
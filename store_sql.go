@@ -0,0 +1,455 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlDialect covers the differences between the SQL databases behind sqlStore.
+type sqlDialect interface {
+	// rebind rewrites the ? placeholders of a query into the database's own syntax.
+	rebind(query string) string
+	// isUniqueViolation reports whether err was caused by a unique constraint.
+	isUniqueViolation(err error) bool
+}
+
+// sqlStore implements Store on top of database/sql. Queries are written with ? placeholders
+// in the SQL shared by SQLite and PostgreSQL.
+type sqlStore struct {
+	db         *sql.DB
+	dialect    sqlDialect
+	migrations string // directory of the migrations applied to db
+}
+
+const deploymentColumns = `uuid, COALESCE(github_url, ''), COALESCE(branch, ''), COALESCE(docs_path, ''),
+	COALESCE(deployment_proxy_url, ''), COALESCE(deployment_url, ''), status,
+	COALESCE(access_mode, ''), access_username, access_password_hash, access_token_ttl,
+	port, pid, pid_start_time, deleted_at, COALESCE(mintlify_version, ''), COALESCE(mode, ''),
+	COALESCE(builder, ''), sandbox_uid`
+
+const apiKeyColumns = "id, name, scopes, repositories, created_at, last_used_at"
+
+func (s *sqlStore) exec(query string, args ...any) (sql.Result, error) {
+	return s.db.Exec(s.dialect.rebind(query), args...)
+}
+
+func (s *sqlStore) query(query string, args ...any) (*sql.Rows, error) {
+	return s.db.Query(s.dialect.rebind(query), args...)
+}
+
+func (s *sqlStore) queryRow(query string, args ...any) *sql.Row {
+	return s.db.QueryRow(s.dialect.rebind(query), args...)
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlStore) CreateDeployment(dep *Deployment) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var port sql.NullInt64
+	if dep.port != 0 {
+		port = sql.NullInt64{Int64: int64(dep.port), Valid: true}
+	}
+	_, err = tx.Exec(s.dialect.rebind("INSERT INTO deployments (uuid, github_url, branch, docs_path, deployment_proxy_url, deployment_url, port, status, queued_at, access_mode, access_username, access_password_hash, access_token_ttl, mintlify_version, mode, builder) VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?)"),
+		dep.UUID, dep.GitHubURL, dep.Branch, dep.DocsPath, dep.DeployURL, dep.upstreamURL, port, StateQueued,
+		dep.policy.mode, dep.policy.username, dep.policy.passwordHash, int64(dep.policy.tokenTTL.Seconds()), dep.MintlifyVersion, dep.Mode, dep.Builder)
+	if err != nil && dep.port != 0 && s.dialect.isUniqueViolation(err) {
+		return errPortTaken
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.recordEvent(tx, dep.UUID, stateNew, StateQueued, "created"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) DeleteDeployment(uuid string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// The deployment row holds its port, so the port is released along with it.
+	if _, err := tx.Exec(s.dialect.rebind("DELETE FROM deployment_events WHERE deployment_uuid = ?"), uuid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.dialect.rebind("DELETE FROM deployments WHERE uuid = ?"), uuid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) GetDeployment(uuid string) (*Deployment, error) {
+	dep, err := scanDeployment(s.queryRow("SELECT "+deploymentColumns+" FROM deployments WHERE uuid = ?", uuid))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errNotFound
+	}
+	return dep, err
+}
+
+func (s *sqlStore) ListDeployments(states ...DeploymentState) ([]*Deployment, error) {
+	if len(states) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(states))
+	for i, state := range states {
+		args[i] = state
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(states)), ", ")
+
+	rows, err := s.query("SELECT "+deploymentColumns+" FROM deployments WHERE status IN ("+placeholders+") AND deleted_at IS NULL ORDER BY created_at, uuid", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var deps []*Deployment
+	for rows.Next() {
+		dep, err := scanDeployment(rows)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, dep)
+	}
+	return deps, rows.Err()
+}
+
+func (s *sqlStore) SoftDeleteDeployment(uuid string, at time.Time) error {
+	_, err := s.exec("UPDATE deployments SET deleted_at = ? WHERE uuid = ? AND deleted_at IS NULL", at.UTC(), uuid)
+	return err
+}
+
+func (s *sqlStore) UndeleteDeployment(uuid string) error {
+	_, err := s.exec("UPDATE deployments SET deleted_at = NULL WHERE uuid = ?", uuid)
+	return err
+}
+
+func (s *sqlStore) ListDeletedDeployments(before time.Time) ([]string, error) {
+	rows, err := s.query("SELECT uuid FROM deployments WHERE deleted_at IS NOT NULL AND deleted_at < ?", before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var uuids []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return nil, err
+		}
+		uuids = append(uuids, uuid)
+	}
+	return uuids, rows.Err()
+}
+
+func scanDeployment(row interface{ Scan(...any) error }) (*Deployment, error) {
+	var dep Deployment
+	var accessUsername, accessPasswordHash sql.NullString
+	var accessTokenTTL, port, pid, pidStartTime, sandboxUID sql.NullInt64
+	var deletedAt sql.NullTime
+	err := row.Scan(&dep.UUID, &dep.GitHubURL, &dep.Branch, &dep.DocsPath,
+		&dep.DeployURL, &dep.upstreamURL, &dep.Status,
+		&dep.policy.mode, &accessUsername, &accessPasswordHash, &accessTokenTTL,
+		&port, &pid, &pidStartTime, &deletedAt, &dep.MintlifyVersion, &dep.Mode, &dep.Builder, &sandboxUID)
+	if err != nil {
+		return nil, err
+	}
+	if deletedAt.Valid {
+		dep.DeletedAt = &deletedAt.Time
+	}
+	dep.policy.username = accessUsername.String
+	dep.policy.passwordHash = accessPasswordHash.String
+	dep.policy.tokenTTL = time.Duration(accessTokenTTL.Int64) * time.Second
+	dep.port = int(port.Int64)
+	dep.sandboxUID = int(sandboxUID.Int64)
+	dep.pid = int(pid.Int64)
+	dep.pidStartTime = pidStartTime.Int64
+	return &dep, nil
+}
+
+func (s *sqlStore) ClaimNextDeployment() (*Deployment, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var uuid string
+	err = tx.QueryRow(s.dialect.rebind(`UPDATE deployments SET status = ?
+		WHERE uuid = (SELECT uuid FROM deployments WHERE status = ? AND deleted_at IS NULL ORDER BY queued_at, uuid LIMIT 1)
+		  AND status = ?
+		RETURNING uuid`), StateStarting, StateQueued, StateQueued).Scan(&uuid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.recordEvent(tx, uuid, StateQueued, StateStarting, "claimed by a worker"); err != nil {
+		return nil, err
+	}
+	dep, err := scanDeployment(tx.QueryRow(s.dialect.rebind("SELECT "+deploymentColumns+" FROM deployments WHERE uuid = ?"), uuid))
+	if err != nil {
+		return nil, err
+	}
+	return dep, tx.Commit()
+}
+
+func (s *sqlStore) TransitionDeployment(uuid string, to DeploymentState, reason string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var from DeploymentState
+	if err := tx.QueryRow(s.dialect.rebind("SELECT status FROM deployments WHERE uuid = ?"), uuid).Scan(&from); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errNotFound
+		}
+		return err
+	}
+	if !canTransition(from, to) {
+		return fmt.Errorf("%w: %s cannot move from %s to %s", errInvalidTransition, uuid, from, to)
+	}
+
+	query := "UPDATE deployments SET status = ?"
+	args := []any{to}
+	switch to {
+	case StateQueued:
+		query += ", queued_at = COALESCE(queued_at, created_at)"
+	case StateFailed, StateCancelled:
+		query += ", error = ?, port = NULL"
+		args = append(args, reason)
+	case StateStopped:
+		query += ", port = NULL"
+	}
+	// Guard against a concurrent transition slipping in between the read and the update.
+	res, err := tx.Exec(s.dialect.rebind(query+" WHERE uuid = ? AND status = ?"), append(args, uuid, from)...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%w: %s changed state concurrently", errInvalidTransition, uuid)
+	}
+	if err := s.recordEvent(tx, uuid, from, to, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) recordEvent(tx *sql.Tx, uuid string, from, to DeploymentState, reason string) error {
+	_, err := tx.Exec(s.dialect.rebind("INSERT INTO deployment_events (deployment_uuid, from_status, to_status, reason, created_at) VALUES (?, ?, ?, ?, ?)"),
+		uuid, from, to, reason, time.Now().UTC())
+	return err
+}
+
+func (s *sqlStore) DeploymentHistory(uuid string) ([]DeploymentEvent, error) {
+	rows, err := s.query("SELECT from_status, to_status, reason, created_at FROM deployment_events WHERE deployment_uuid = ? ORDER BY id", uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	events := []DeploymentEvent{}
+	for rows.Next() {
+		var e DeploymentEvent
+		var reason sql.NullString
+		if err := rows.Scan(&e.From, &e.To, &reason, &e.At); err != nil {
+			return nil, err
+		}
+		e.Reason = reason.String
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *sqlStore) QueuePosition(uuid string) (int, error) {
+	var position int
+	err := s.queryRow(`SELECT COUNT(*) FROM deployments q, deployments d
+		WHERE d.uuid = ? AND q.status = ?
+		  AND (q.queued_at < d.queued_at OR (q.queued_at = d.queued_at AND q.uuid <= d.uuid))`, uuid, StateQueued).Scan(&position)
+	return position, err
+}
+
+func (s *sqlStore) SetDeploymentProcess(uuid string, pid int, startTime int64) error {
+	_, err := s.exec("UPDATE deployments SET pid = ?, pid_start_time = ? WHERE uuid = ?", pid, startTime, uuid)
+	return err
+}
+
+func (s *sqlStore) ClearDeploymentProcess(uuid string, pid int) error {
+	_, err := s.exec("UPDATE deployments SET pid = NULL, pid_start_time = NULL WHERE uuid = ? AND pid = ?", uuid, pid)
+	return err
+}
+
+func (s *sqlStore) AssignPort(uuid string, port int, upstreamURL string) error {
+	_, err := s.exec("UPDATE deployments SET port = ?, deployment_url = ? WHERE uuid = ?", port, upstreamURL, uuid)
+	if err != nil && s.dialect.isUniqueViolation(err) {
+		return errPortTaken
+	}
+	return err
+}
+
+func (s *sqlStore) AssignedPorts() (map[int]bool, error) {
+	rows, err := s.query("SELECT port FROM deployments WHERE port IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	used := make(map[int]bool)
+	for rows.Next() {
+		var port int
+		if err := rows.Scan(&port); err != nil {
+			return nil, err
+		}
+		used[port] = true
+	}
+	return used, rows.Err()
+}
+
+func (s *sqlStore) AssignSandboxUID(uuid string, uid int) error {
+	_, err := s.exec("UPDATE deployments SET sandbox_uid = ? WHERE uuid = ?", uid, uuid)
+	if err != nil && s.dialect.isUniqueViolation(err) {
+		return errSandboxUIDTaken
+	}
+	return err
+}
+
+func (s *sqlStore) AssignedSandboxUIDs() (map[int]bool, error) {
+	rows, err := s.query("SELECT sandbox_uid FROM deployments WHERE sandbox_uid IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	used := make(map[int]bool)
+	for rows.Next() {
+		var uid int
+		if err := rows.Scan(&uid); err != nil {
+			return nil, err
+		}
+		used[uid] = true
+	}
+	return used, rows.Err()
+}
+
+func (s *sqlStore) CreateAPIKey(k *APIKey, keyHash string) error {
+	_, err := s.exec("INSERT INTO api_keys (id, name, key_hash, scopes, repositories, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		k.ID, k.Name, keyHash, strings.Join(k.Scopes, ","), strings.Join(k.Repositories, ","), k.CreatedAt)
+	return err
+}
+
+func (s *sqlStore) PutAPIKey(k *APIKey, keyHash string) error {
+	_, err := s.exec(`INSERT INTO api_keys (id, name, key_hash, scopes, repositories, created_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET key_hash = excluded.key_hash, scopes = excluded.scopes, revoked_at = NULL`,
+		k.ID, k.Name, keyHash, strings.Join(k.Scopes, ","), strings.Join(k.Repositories, ","), k.CreatedAt)
+	return err
+}
+
+func (s *sqlStore) ListAPIKeys() ([]APIKey, error) {
+	rows, err := s.query("SELECT " + apiKeyColumns + " FROM api_keys WHERE revoked_at IS NULL ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *sqlStore) FindAPIKey(keyHash string) (*APIKey, error) {
+	k, err := scanAPIKey(s.queryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL", keyHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errNotFound
+	}
+	return k, err
+}
+
+func (s *sqlStore) RevokeAPIKey(id string) (bool, error) {
+	res, err := s.exec("UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqlStore) TouchAPIKey(id string) error {
+	_, err := s.exec("UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	return err
+}
+
+func (s *sqlStore) CountAPIKeys() (int, error) {
+	var count int
+	err := s.queryRow("SELECT COUNT(*) FROM api_keys WHERE revoked_at IS NULL").Scan(&count)
+	return count, err
+}
+
+func (s *sqlStore) CheckWritable() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// A no-op update still needs write access, and is rolled back anyway.
+	_, err = tx.Exec("UPDATE schema_migrations SET dirty = dirty")
+	return err
+}
+
+func (s *sqlStore) MigrationStatus() (current, latest uint, dirty bool, err error) {
+	if err = s.queryRow("SELECT version, dirty FROM schema_migrations").Scan(&current, &dirty); err != nil {
+		return 0, 0, false, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(s.migrations, "*.up.sql"))
+	if err != nil {
+		return 0, 0, false, err
+	}
+	for _, f := range files {
+		prefix, _, _ := strings.Cut(filepath.Base(f), "_")
+		v, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		latest = max(latest, uint(v))
+	}
+	return current, latest, dirty, nil
+}
+
+func scanAPIKey(row interface{ Scan(...any) error }) (*APIKey, error) {
+	var k APIKey
+	var scopes, repositories sql.NullString
+	var lastUsedAt sql.NullTime
+	if err := row.Scan(&k.ID, &k.Name, &scopes, &repositories, &k.CreatedAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+	k.Scopes = splitList(scopes.String)
+	k.Repositories = splitList(repositories.String)
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	return &k, nil
+}
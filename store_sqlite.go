@@ -0,0 +1,53 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golang-migrate/migrate/v4"
+	migratesqlite3 "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/mattn/go-sqlite3"
+)
+
+const sqliteMigrations = "migrations/sqlite"
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) rebind(query string) string { return query }
+
+func (sqliteDialect) isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// openSQLiteStore opens the SQLite database at path, creating it if needed, and applies migrations/sqlite.
+func openSQLiteStore(path string) (Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	// Transactions take the write lock up front, otherwise two of them reading before writing
+	// deadlock instead of waiting for each other.
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := migratesqlite3.WithInstance(db, &migratesqlite3.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SQLite driver instance: %w", err)
+	}
+	m, err := migrate.NewWithDatabaseInstance("file://"+sqliteMigrations, "sqlite", driver)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migration instance: %w", err)
+	}
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return nil, fmt.Errorf("failed to apply migrations: %w", err)
+	}
+
+	return &sqlStore{db: db, dialect: sqliteDialect{}, migrations: sqliteMigrations}, nil
+}
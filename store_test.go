@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testStores lists the databases the store suite runs against. PostgreSQL is only tested when
+// TEST_POSTGRES_DSN points at a database the tests may empty.
+var testStores = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{name: "sqlite", open: func(t *testing.T) Store {
+		s, err := openSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("failed to open store: %v", err)
+		}
+		return s
+	}},
+	{name: "postgres", open: func(t *testing.T) Store {
+		dsn := os.Getenv("TEST_POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("TEST_POSTGRES_DSN is not set")
+		}
+		s, err := openPostgresStore(dsn)
+		if err != nil {
+			t.Fatalf("failed to open store: %v", err)
+		}
+		if _, err := s.(*sqlStore).db.Exec("TRUNCATE deployments, deployment_events, api_keys"); err != nil {
+			t.Fatalf("failed to empty the database: %v", err)
+		}
+		return s
+	}},
+}
+
+// forEachStore runs test against an empty store of every database in testStores.
+func forEachStore(t *testing.T, test func(t *testing.T, s Store)) {
+	for _, ts := range testStores {
+		t.Run(ts.name, func(t *testing.T) {
+			s := ts.open(t)
+			t.Cleanup(func() { _ = s.Close() })
+			test(t, s)
+		})
+	}
+}
+
+// useTestStore points the package at a fresh SQLite store for the duration of the test.
+func useTestStore(t *testing.T) Store {
+	t.Helper()
+	s := testStores[0].open(t)
+	previous := store
+	store = s
+	t.Cleanup(func() {
+		store = previous
+		_ = s.Close()
+	})
+	return s
+}
+
+// chdirTemp runs the test from an empty directory, where deployments keep their checkouts.
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	return dir
+}
+
+// createTestDeployment stores a queued deployment and moves it through states.
+func createTestDeployment(t *testing.T, s Store, uuid string, states ...DeploymentState) {
+	t.Helper()
+	dep := &Deployment{UUID: uuid, GitHubURL: "https://github.com/acme/docs", Branch: "main", DocsPath: "docs.json", Mode: deploymentModeDev, Builder: builderMintlify}
+	if err := s.CreateDeployment(dep); err != nil {
+		t.Fatalf("CreateDeployment(%s) error = %v", uuid, err)
+	}
+	for _, state := range states {
+		if err := s.TransitionDeployment(uuid, state, "test"); err != nil {
+			t.Fatalf("TransitionDeployment(%s, %s) error = %v", uuid, state, err)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query    string
+		sqlite   string
+		postgres string
+	}{
+		{query: "SELECT 1", sqlite: "SELECT 1", postgres: "SELECT 1"},
+		{query: "DELETE FROM deployments WHERE uuid = ?", sqlite: "DELETE FROM deployments WHERE uuid = ?", postgres: "DELETE FROM deployments WHERE uuid = $1"},
+		{query: "UPDATE deployments SET port = ?, deployment_url = ? WHERE uuid = ?", sqlite: "UPDATE deployments SET port = ?, deployment_url = ? WHERE uuid = ?", postgres: "UPDATE deployments SET port = $1, deployment_url = $2 WHERE uuid = $3"},
+		{query: "status IN (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", sqlite: "status IN (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", postgres: "status IN ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"},
+	}
+	for _, tt := range tests {
+		if got := (sqliteDialect{}).rebind(tt.query); got != tt.sqlite {
+			t.Errorf("sqlite rebind(%q) = %q, want %q", tt.query, got, tt.sqlite)
+		}
+		if got := (postgresDialect{}).rebind(tt.query); got != tt.postgres {
+			t.Errorf("postgres rebind(%q) = %q, want %q", tt.query, got, tt.postgres)
+		}
+	}
+}
+
+func TestStoreDeployments(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		createTestDeployment(t, s, "dep-queued")
+		createTestDeployment(t, s, "dep-running", StateStarting, StateRunning)
+
+		dep, err := s.GetDeployment("dep-queued")
+		if err != nil {
+			t.Fatalf("GetDeployment() error = %v", err)
+		}
+		if dep.Status != StateQueued || dep.GitHubURL != "https://github.com/acme/docs" || dep.Mode != deploymentModeDev || dep.Builder != builderMintlify {
+			t.Errorf("GetDeployment() = %+v", dep)
+		}
+		if _, err := s.GetDeployment("dep-missing"); !errors.Is(err, errNotFound) {
+			t.Errorf("GetDeployment() of a missing deployment error = %v, want errNotFound", err)
+		}
+
+		tests := []struct {
+			states []DeploymentState
+			want   []string
+		}{
+			{states: nil, want: nil},
+			{states: []DeploymentState{StateQueued}, want: []string{"dep-queued"}},
+			{states: []DeploymentState{StateRunning, StateStarting}, want: []string{"dep-running"}},
+			{states: []DeploymentState{StateQueued, StateRunning}, want: []string{"dep-queued", "dep-running"}},
+			{states: []DeploymentState{StateFailed}, want: nil},
+		}
+		for _, tt := range tests {
+			deps, err := s.ListDeployments(tt.states...)
+			if err != nil {
+				t.Fatalf("ListDeployments(%v) error = %v", tt.states, err)
+			}
+			var got []string
+			for _, dep := range deps {
+				got = append(got, dep.UUID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ListDeployments(%v) = %v, want %v", tt.states, got, tt.want)
+			}
+		}
+	})
+}
+
+func TestStoreTransitions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		createTestDeployment(t, s, "dep")
+
+		tests := []struct {
+			to      DeploymentState
+			wantErr bool
+		}{
+			{to: StateRunning, wantErr: true},
+			{to: StateStarting},
+			{to: StateRunning},
+			{to: StateCancelled, wantErr: true},
+			{to: StateFailed},
+			{to: StateQueued},
+		}
+		for _, tt := range tests {
+			err := s.TransitionDeployment("dep", tt.to, "to "+string(tt.to))
+			if tt.wantErr != errors.Is(err, errInvalidTransition) || (!tt.wantErr && err != nil) {
+				t.Fatalf("TransitionDeployment(%s) error = %v, want error %v", tt.to, err, tt.wantErr)
+			}
+		}
+		if err := s.TransitionDeployment("dep-missing", StateStarting, ""); !errors.Is(err, errNotFound) {
+			t.Errorf("TransitionDeployment() of a missing deployment error = %v, want errNotFound", err)
+		}
+
+		events, err := s.DeploymentHistory("dep")
+		if err != nil {
+			t.Fatalf("DeploymentHistory() error = %v", err)
+		}
+		want := []DeploymentEvent{
+			{From: stateNew, To: StateQueued, Reason: "created"},
+			{From: StateQueued, To: StateStarting, Reason: "to starting"},
+			{From: StateStarting, To: StateRunning, Reason: "to running"},
+			{From: StateRunning, To: StateFailed, Reason: "to failed"},
+			{From: StateFailed, To: StateQueued, Reason: "to queued"},
+		}
+		if len(events) != len(want) {
+			t.Fatalf("DeploymentHistory() = %+v, want %d events", events, len(want))
+		}
+		for i, e := range events {
+			if e.From != want[i].From || e.To != want[i].To || e.Reason != want[i].Reason {
+				t.Errorf("event %d = %+v, want %+v", i, e, want[i])
+			}
+		}
+	})
+}
+
+func TestStoreQueue(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		for _, uuid := range []string{"dep-a", "dep-b", "dep-c"} {
+			createTestDeployment(t, s, uuid)
+		}
+		if err := s.SoftDeleteDeployment("dep-a", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		if pos, err := s.QueuePosition("dep-c"); err != nil || pos != 3 {
+			t.Errorf("QueuePosition(dep-c) = %d, %v, want 3", pos, err)
+		}
+
+		// Claims skip deleted deployments and come back through RETURNING.
+		for _, want := range []string{"dep-b", "dep-c"} {
+			dep, err := s.ClaimNextDeployment()
+			if err != nil {
+				t.Fatalf("ClaimNextDeployment() error = %v", err)
+			}
+			if dep == nil || dep.UUID != want || dep.Status != StateStarting {
+				t.Fatalf("ClaimNextDeployment() = %+v, want %s starting", dep, want)
+			}
+		}
+		if dep, err := s.ClaimNextDeployment(); err != nil || dep != nil {
+			t.Errorf("ClaimNextDeployment() on an empty queue = %+v, %v, want nil", dep, err)
+		}
+
+		events, err := s.DeploymentHistory("dep-b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last := events[len(events)-1]; last.From != StateQueued || last.To != StateStarting {
+			t.Errorf("last event of a claimed deployment = %+v", last)
+		}
+	})
+}
+
+func TestStorePorts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		createTestDeployment(t, s, "dep-a", StateStarting)
+		createTestDeployment(t, s, "dep-b", StateStarting)
+
+		if err := s.AssignPort("dep-a", 5000, "http://localhost:5000"); err != nil {
+			t.Fatalf("AssignPort() error = %v", err)
+		}
+		// The unique index on the port is what keeps concurrent reservations apart.
+		if err := s.AssignPort("dep-b", 5000, "http://localhost:5000"); !errors.Is(err, errPortTaken) {
+			t.Fatalf("AssignPort() of a taken port error = %v, want errPortTaken", err)
+		}
+		if err := s.AssignPort("dep-b", 5001, "http://localhost:5001"); err != nil {
+			t.Fatalf("AssignPort() error = %v", err)
+		}
+		if used, err := s.AssignedPorts(); err != nil || len(used) != 2 || !used[5000] || !used[5001] {
+			t.Errorf("AssignedPorts() = %v, %v, want 5000 and 5001", used, err)
+		}
+
+		// Deployments created with a port hold it from their first row on.
+		queued := &Deployment{UUID: "dep-c", Mode: deploymentModeDev, port: 5001, upstreamURL: "http://localhost:5001"}
+		if err := s.CreateDeployment(queued); !errors.Is(err, errPortTaken) {
+			t.Fatalf("CreateDeployment() with a taken port error = %v, want errPortTaken", err)
+		}
+		if _, err := s.GetDeployment("dep-c"); !errors.Is(err, errNotFound) {
+			t.Errorf("GetDeployment() of a deployment created with a taken port error = %v, want errNotFound", err)
+		}
+		queued.port, queued.upstreamURL = 5002, "http://localhost:5002"
+		if err := s.CreateDeployment(queued); err != nil {
+			t.Fatalf("CreateDeployment() with a port error = %v", err)
+		}
+		if dep, err := s.GetDeployment("dep-c"); err != nil || dep.port != 5002 || dep.upstreamURL != "http://localhost:5002" || dep.Status != StateQueued {
+			t.Errorf("GetDeployment() = %+v, %v, want queued on port 5002", dep, err)
+		}
+		if err := s.TransitionDeployment("dep-c", StateCancelled, "test"); err != nil {
+			t.Fatal(err)
+		}
+
+		// Stopping a deployment releases its port.
+		if err := s.TransitionDeployment("dep-a", StateFailed, "test"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AssignPort("dep-b", 5000, "http://localhost:5000"); err != nil {
+			t.Errorf("AssignPort() of a released port error = %v", err)
+		}
+		dep, err := s.GetDeployment("dep-b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dep.port != 5000 || dep.upstreamURL != "http://localhost:5000" {
+			t.Errorf("deployment has port %d and upstream %q, want 5000", dep.port, dep.upstreamURL)
+		}
+	})
+}
+
+func TestStoreDeletion(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		createTestDeployment(t, s, "dep-old", StateStarting)
+		createTestDeployment(t, s, "dep-new")
+		if err := s.AssignPort("dep-old", 5000, "http://localhost:5000"); err != nil {
+			t.Fatal(err)
+		}
+
+		now := time.Now()
+		if err := s.SoftDeleteDeployment("dep-old", now.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SoftDeleteDeployment("dep-new", now); err != nil {
+			t.Fatal(err)
+		}
+		if uuids, err := s.ListDeletedDeployments(now.Add(-time.Minute)); err != nil || !slices.Equal(uuids, []string{"dep-old"}) {
+			t.Errorf("ListDeletedDeployments() = %v, %v, want dep-old", uuids, err)
+		}
+		if deps, err := s.ListDeployments(StateQueued, StateStarting); err != nil || len(deps) != 0 {
+			t.Errorf("ListDeployments() = %v, %v, want no deleted deployments", deps, err)
+		}
+
+		if err := s.UndeleteDeployment("dep-new"); err != nil {
+			t.Fatal(err)
+		}
+		if dep, err := s.GetDeployment("dep-new"); err != nil || dep.DeletedAt != nil {
+			t.Errorf("GetDeployment() after undelete = %+v, %v", dep, err)
+		}
+
+		if err := s.DeleteDeployment("dep-old"); err != nil {
+			t.Fatalf("DeleteDeployment() error = %v", err)
+		}
+		if _, err := s.GetDeployment("dep-old"); !errors.Is(err, errNotFound) {
+			t.Errorf("GetDeployment() after delete error = %v, want errNotFound", err)
+		}
+		if events, err := s.DeploymentHistory("dep-old"); err != nil || len(events) != 0 {
+			t.Errorf("DeploymentHistory() after delete = %v, %v, want none", events, err)
+		}
+		if used, err := s.AssignedPorts(); err != nil || used[5000] {
+			t.Errorf("AssignedPorts() after delete = %v, %v, want 5000 released", used, err)
+		}
+	})
+}
+
+func TestStoreAPIKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		created := time.Now().UTC().Truncate(time.Second)
+		key := &APIKey{ID: "key-1", Name: "ci", Scopes: []string{scopeAdmin}, Repositories: []string{"acme/docs"}, CreatedAt: created}
+		if err := s.CreateAPIKey(key, "hash-1"); err != nil {
+			t.Fatalf("CreateAPIKey() error = %v", err)
+		}
+		// Key hashes are unique as well.
+		if err := s.CreateAPIKey(&APIKey{ID: "key-2", Name: "dup", CreatedAt: created}, "hash-1"); err == nil {
+			t.Error("CreateAPIKey() with a duplicate hash succeeded")
+		}
+
+		found, err := s.FindAPIKey("hash-1")
+		if err != nil {
+			t.Fatalf("FindAPIKey() error = %v", err)
+		}
+		if found.ID != "key-1" || !slices.Equal(found.Scopes, key.Scopes) || !slices.Equal(found.Repositories, key.Repositories) || found.LastUsedAt != nil {
+			t.Errorf("FindAPIKey() = %+v", found)
+		}
+		if err := s.TouchAPIKey("key-1"); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := s.FindAPIKey("hash-1"); err != nil || found.LastUsedAt == nil {
+			t.Errorf("FindAPIKey() after touch = %+v, %v, want a last use", found, err)
+		}
+
+		if revoked, err := s.RevokeAPIKey("key-1"); err != nil || !revoked {
+			t.Fatalf("RevokeAPIKey() = %v, %v, want true", revoked, err)
+		}
+		if revoked, err := s.RevokeAPIKey("key-1"); err != nil || revoked {
+			t.Errorf("RevokeAPIKey() of a revoked key = %v, %v, want false", revoked, err)
+		}
+		if _, err := s.FindAPIKey("hash-1"); !errors.Is(err, errNotFound) {
+			t.Errorf("FindAPIKey() of a revoked key error = %v, want errNotFound", err)
+		}
+		if count, err := s.CountAPIKeys(); err != nil || count != 0 {
+			t.Errorf("CountAPIKeys() = %d, %v, want 0", count, err)
+		}
+
+		// Putting a revoked key reinstates it under its new hash.
+		if err := s.PutAPIKey(key, "hash-2"); err != nil {
+			t.Fatalf("PutAPIKey() error = %v", err)
+		}
+		keys, err := s.ListAPIKeys()
+		if err != nil || len(keys) != 1 || keys[0].ID != "key-1" {
+			t.Errorf("ListAPIKeys() = %+v, %v, want key-1", keys, err)
+		}
+		if _, err := s.FindAPIKey("hash-2"); err != nil {
+			t.Errorf("FindAPIKey() of the new hash error = %v", err)
+		}
+	})
+}
+
+func TestStoreHealth(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		if err := s.CheckWritable(); err != nil {
+			t.Errorf("CheckWritable() error = %v", err)
+		}
+		current, latest, dirty, err := s.MigrationStatus()
+		if err != nil || dirty || current == 0 || current != latest {
+			t.Errorf("MigrationStatus() = %d, %d, %v, %v, want the latest version applied", current, latest, dirty, err)
+		}
+	})
+}
+
+func TestStoreSandboxUIDs(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		createTestDeployment(t, s, "dep-a")
+		createTestDeployment(t, s, "dep-b")
+
+		if err := s.AssignSandboxUID("dep-a", 10001); err != nil {
+			t.Fatalf("AssignSandboxUID() error = %v", err)
+		}
+		if err := s.AssignSandboxUID("dep-b", 10001); !errors.Is(err, errSandboxUIDTaken) {
+			t.Fatalf("AssignSandboxUID() of a taken UID error = %v, want errSandboxUIDTaken", err)
+		}
+		// Deleted deployments keep their UID, their checkout is still owned by it.
+		if err := s.SoftDeleteDeployment("dep-a", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if used, err := s.AssignedSandboxUIDs(); err != nil || len(used) != 1 || !used[10001] {
+			t.Errorf("AssignedSandboxUIDs() = %v, %v, want 10001", used, err)
+		}
+		if dep, err := s.GetDeployment("dep-a"); err != nil || dep.sandboxUID != 10001 {
+			t.Errorf("GetDeployment() = %+v, %v, want sandbox UID 10001", dep, err)
+		}
+	})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mintlify-previewer-backend/log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// latestMintlifyVersion installs whatever npm resolves as latest. It is cached like any other
+// version, so pin versions to pick up new releases deliberately.
+const latestMintlifyVersion = "latest"
+
+var (
+	mintlifyVersionPattern = regexp.MustCompile(`^(latest|\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?)$`)
+
+	errInvalidMintlifyVersion = errors.New("mintlify_version must be a version such as 4.0.500, or latest")
+
+	mintlifyToolchain = newToolchain(toolchainConfig{
+		dir:            getEnv("MINTLIFY_TOOLCHAIN_DIR", "./.toolchains/mintlify"),
+		defaultVersion: getEnv("MINTLIFY_VERSION", ""),
+		tarballDir:     getEnv("MINTLIFY_TARBALL_DIR", ""),
+		npmCache:       getEnv("MINTLIFY_NPM_CACHE", ""),
+	})
+)
+
+// toolchainConfig locates the Mintlify CLI versions and where to install them from.
+type toolchainConfig struct {
+	dir string
+	// defaultVersion is used by deployments that don't pick one. If empty, the mintlify found in PATH
+	// is used, or the latest version is installed if there is none.
+	defaultVersion string
+	// tarballDir holds mintlify-<version>.tgz packages, as made by npm pack, installed instead of
+	// fetching the package from the registry.
+	tarballDir string
+	// npmCache is an npm cache to install from without network access.
+	npmCache string
+}
+
+// toolchain installs Mintlify CLI versions side by side, each under a directory of its own,
+// so that deployments can pin the version they are previewed with.
+type toolchain struct {
+	cfg toolchainConfig
+
+	mu    sync.Mutex
+	locks map[string]chan struct{} // held while a version is being installed
+}
+
+func newToolchain(cfg toolchainConfig) *toolchain {
+	if dir, err := filepath.Abs(cfg.dir); err == nil {
+		cfg.dir = dir
+	}
+	return &toolchain{cfg: cfg, locks: make(map[string]chan struct{})}
+}
+
+// validateMintlifyVersion checks a version requested for a deployment; empty selects the default.
+func validateMintlifyVersion(version string) error {
+	if version != "" && !mintlifyVersionPattern.MatchString(version) {
+		return errInvalidMintlifyVersion
+	}
+	return nil
+}
+
+// resolve returns the version to use for a requested one, or "" for the mintlify in PATH.
+func (tc *toolchain) resolve(version string) string {
+	if version != "" {
+		return version
+	}
+	if tc.cfg.defaultVersion != "" {
+		return tc.cfg.defaultVersion
+	}
+	if _, err := exec.LookPath("mintlify"); err == nil {
+		return ""
+	}
+	return latestMintlifyVersion
+}
+
+// binaryPath returns where the CLI of an installed version lives.
+func (tc *toolchain) binaryPath(version string) string {
+	return filepath.Join(tc.cfg.dir, version, "node_modules", ".bin", "mintlify")
+}
+
+// installed returns the path of the CLI for version, or "" if it isn't installed.
+func (tc *toolchain) installed(version string) string {
+	if version == "" {
+		path, _ := exec.LookPath("mintlify")
+		return path
+	}
+	path := tc.binaryPath(version)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// ensure returns the path of the Mintlify CLI for a requested version, installing it first if needed.
+// Concurrent requests for the same version wait for a single install.
+func (tc *toolchain) ensure(ctx context.Context, version string) (string, error) {
+	version = tc.resolve(version)
+	if path := tc.installed(version); path != "" {
+		return path, nil
+	}
+
+	unlock, err := tc.lock(ctx, version)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// Someone else may have installed it while we were waiting.
+	if path := tc.installed(version); path != "" {
+		return path, nil
+	}
+	if err := tc.install(ctx, version); err != nil {
+		return "", err
+	}
+	return tc.binaryPath(version), nil
+}
+
+// lock takes the install lock of a version, giving up if ctx is done first.
+func (tc *toolchain) lock(ctx context.Context, version string) (func(), error) {
+	tc.mu.Lock()
+	l, ok := tc.locks[version]
+	if !ok {
+		l = make(chan struct{}, 1)
+		tc.locks[version] = l
+	}
+	tc.mu.Unlock()
+
+	select {
+	case l <- struct{}{}:
+		return func() { <-l }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// install runs npm into a scratch directory and moves it into place once it succeeded,
+// so that an interrupted install never looks like a complete one.
+func (tc *toolchain) install(ctx context.Context, version string) error {
+	logger := log.FromContext(ctx)
+	target := filepath.Join(tc.cfg.dir, version)
+	scratch := target + ".partial"
+	if err := os.RemoveAll(scratch); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(scratch, 0o755); err != nil {
+		return fmt.Errorf("failed to create toolchain directory: %w", err)
+	}
+
+	pkg, err := tc.packageSource(version)
+	if err != nil {
+		return err
+	}
+	args := []string{"install", "--prefix", scratch, "--no-audit", "--no-fund", "--no-save", pkg}
+	if tc.cfg.npmCache != "" {
+		args = append(args, "--cache", tc.cfg.npmCache, "--offline")
+	}
+
+	logger.Infof("Installing Mintlify %s from %s", version, pkg)
+	cmd := exec.CommandContext(ctx, "npm", args...)
+	killProcessGroupOnCancel(cmd)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		_ = os.RemoveAll(scratch)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to install Mintlify %s: %w", version, err)
+	}
+
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+	if err := os.Rename(scratch, target); err != nil {
+		return fmt.Errorf("failed to move Mintlify %s into place: %w", version, err)
+	}
+	logger.Infof("Installed Mintlify %s", version)
+	return nil
+}
+
+// packageSource returns what to hand npm install for version: a local tarball if there is one,
+// the registry package otherwise.
+func (tc *toolchain) packageSource(version string) (string, error) {
+	if tc.cfg.tarballDir != "" {
+		tarball := filepath.Join(tc.cfg.tarballDir, "mintlify-"+version+".tgz")
+		_, err := os.Stat(tarball)
+		if err == nil {
+			return filepath.Abs(tarball)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "mintlify@" + version, nil
+}
+
+// preinstall installs the versions in MINTLIFY_PREINSTALL, the default version by default,
+// so that the first deployments using them don't wait for npm.
+func (tc *toolchain) preinstall() {
+	var fallback []string
+	if tc.cfg.defaultVersion != "" {
+		fallback = []string{tc.cfg.defaultVersion}
+	}
+	versions := getEnvList("MINTLIFY_PREINSTALL", fallback)
+	if len(versions) == 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), installTimeout*time.Duration(len(versions)))
+		defer cancel()
+		for _, version := range versions {
+			if err := validateMintlifyVersion(version); err != nil {
+				log.Warnf("Not preinstalling Mintlify %q: %v", version, err)
+				continue
+			}
+			if _, err := tc.ensure(ctx, version); err != nil {
+				log.Errorf("Failed to preinstall Mintlify %s: %v", version, err)
+			}
+		}
+	}()
+}
+
+// check reports whether the default version is ready to use without an install.
+func (tc *toolchain) check() error {
+	version := tc.resolve("")
+	if tc.installed(version) == "" {
+		return fmt.Errorf("Mintlify %s is not installed yet", version)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mintlify-previewer-backend/log"
+	"net/http"
+	"os"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trace exporters selectable with OTEL_TRACES_EXPORTER.
+const (
+	tracesExporterNone   = "none"
+	tracesExporterOTLP   = "otlp"
+	tracesExporterStdout = "stdout"
+)
+
+const serviceName = "mintlify-previewer"
+
+var (
+	// tracer is a no-op until initTracing installs an exporting provider.
+	tracer = otel.Tracer("mintlify-previewer-backend")
+
+	// deploymentSpans holds the root spans of deployments that are being checked, queued or built.
+	deploymentSpans   = make(map[string]trace.Span)
+	deploymentSpansMu sync.Mutex
+
+	deploymentUUIDKey = attribute.Key("deployment.uuid")
+)
+
+// initTracing installs the trace exporter selected by OTEL_TRACES_EXPORTER and returns a function
+// flushing and stopping it. The OTLP exporter sends over HTTP and is configured with the standard
+// OTEL_EXPORTER_OTLP_* variables, e.g. OTEL_EXPORTER_OTLP_ENDPOINT=http://localhost:4318.
+func initTracing() func(context.Context) error {
+	noop := func(context.Context) error { return nil }
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := getEnv("OTEL_TRACES_EXPORTER", tracesExporterNone); name {
+	case tracesExporterNone:
+		return noop
+	case tracesExporterOTLP:
+		exporter, err = otlptracehttp.New(context.Background())
+	case tracesExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		log.Fatalf("Unknown OTEL_TRACES_EXPORTER %q, expected %s, %s or %s", name, tracesExporterOTLP, tracesExporterStdout, tracesExporterNone)
+	}
+	if err != nil {
+		log.Fatal("failed to create trace exporter: ", err)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults.
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		log.Warnf("Failed to detect trace resource: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	log.Infof("Exporting traces via %s", os.Getenv("OTEL_TRACES_EXPORTER"))
+	return provider.Shutdown
+}
+
+// startDeploymentSpan starts the root span of a new deployment, linked to the trace of the request that
+// created it, if any. The span stays open while the deployment waits in the queue and is built,
+// and ends with endDeploymentSpan.
+func startDeploymentSpan(r *http.Request, uuid string) context.Context {
+	parent := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(r.Context(), "deployment",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(parent)),
+		trace.WithAttributes(deploymentUUIDKey.String(uuid)),
+	)
+	deploymentSpansMu.Lock()
+	deploymentSpans[uuid] = span
+	deploymentSpansMu.Unlock()
+	return ctx
+}
+
+// deploymentContext returns a context carrying the root span of a deployment. Deployments queued
+// before a restart get a new one.
+func deploymentContext(uuid string) context.Context {
+	deploymentSpansMu.Lock()
+	defer deploymentSpansMu.Unlock()
+	span, ok := deploymentSpans[uuid]
+	if !ok {
+		_, span = tracer.Start(context.Background(), "deployment",
+			trace.WithNewRoot(),
+			trace.WithAttributes(deploymentUUIDKey.String(uuid)),
+		)
+		deploymentSpans[uuid] = span
+	}
+	return trace.ContextWithSpan(context.Background(), span)
+}
+
+// endDeploymentSpan ends the root span of a deployment, marking it as failed if err is set.
+func endDeploymentSpan(uuid string, err error) {
+	deploymentSpansMu.Lock()
+	span, ok := deploymentSpans[uuid]
+	delete(deploymentSpans, uuid)
+	deploymentSpansMu.Unlock()
+	if !ok {
+		return
+	}
+	recordSpanError(span, err)
+	span.End()
+}
+
+// recordSpanError marks a span as failed with err, if it is set.
+func recordSpanError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// startStageSpan starts the span of a deployment stage as a child of the deployment span in ctx.
+func startStageSpan(ctx context.Context, stage string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "deployment."+stage, trace.WithAttributes(attribute.String("deployment.stage", stage)))
+}
+
+// startProxySpan starts a server span for a request proxied to a preview, continuing the caller's trace.
+func startProxySpan(r *http.Request, uuid string) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return tracer.Start(ctx, fmt.Sprintf("proxy %s", r.Method),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			deploymentUUIDKey.String(uuid),
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		),
+	)
+}
+
+// endProxySpan records the status code of a proxied request and ends its span.
+func endProxySpan(span trace.Span, status int) {
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= http.StatusInternalServerError {
+		recordSpanError(span, errors.New(http.StatusText(status)))
+	}
+	span.End()
+}
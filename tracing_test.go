@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"mintlify-previewer-backend/log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// fakeMkDocs builds a one page site and serves the checkout over HTTP like mkdocs serve.
+const fakeMkDocs = `#!/bin/sh
+case "$1" in
+build) mkdir -p site && echo '<h1>docs</h1>' > site/index.html ;;
+serve) exec python3 -m http.server --bind 127.0.0.1 "${5##*:}" ;;
+esac
+`
+
+// useTestTracer records the spans of the test in memory.
+func useTestTracer(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous, propagator := tracer, otel.GetTextMapPropagator()
+	tracer = provider.Tracer("test")
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		tracer = previous
+		otel.SetTextMapPropagator(propagator)
+		_ = provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+// useTestRepository makes clones of https://github.com/acme/docs come from a local MkDocs repository.
+func useTestRepository(t *testing.T) {
+	t.Helper()
+	root := t.TempDir()
+	repo := filepath.Join(root, "acme", "docs.git")
+	if err := os.MkdirAll(filepath.Join(repo, "docs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"mkdocs.yml": "site_name: Docs\n", "docs/index.md": "# Docs\n"} {
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{{"init", "-q", "-b", "main"}, {"add", "."}, {"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-qm", "docs"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	schemes := allowedGitSchemes
+	t.Cleanup(func() { allowedGitSchemes = schemes })
+	allowedGitSchemes = []string{"https", "file"}
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "url.file://"+root+"/.insteadOf")
+	t.Setenv("GIT_CONFIG_VALUE_0", "https://github.com/")
+
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "mkdocs"), []byte(fakeMkDocs), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestDeploymentTrace(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+	s := useTestStore(t)
+	chdirTemp(t)
+	usePortRange(t, 2)
+	useTestRepository(t)
+	exporter := useTestTracer(t)
+	settings := sandboxSettings
+	t.Cleanup(func() { sandboxSettings = settings })
+	sandboxSettings = sandboxConfig{uid: -1, gid: -1, home: t.TempDir()}
+
+	tests := []struct {
+		uuid   string
+		mode   string
+		stages []string
+	}{
+		{uuid: "01jc0000000000000000000001", mode: deploymentModeStatic, stages: []string{stageClone, stageInstall, stageBuild}},
+		{uuid: "01jc0000000000000000000002", mode: deploymentModeDev, stages: []string{stageClone, stageInstall, stageStart, stageReady}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			exporter.Reset()
+			r := httptest.NewRequest(http.MethodPost, "/deploy", nil)
+			// The trace of the request creating the deployment is linked, not continued.
+			r.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+			startDeploymentSpan(r, tt.uuid)
+			dep := &Deployment{UUID: tt.uuid, GitHubURL: "https://github.com/acme/docs/pull/1", Branch: "main", Mode: tt.mode, Builder: builderMkDocs, DocsPath: "mkdocs.yml"}
+			if err := s.CreateDeployment(dep); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, dep, err := claimNextDeployment()
+			if err != nil || dep == nil {
+				t.Fatalf("claimNextDeployment() = %v, %v", dep, err)
+			}
+			b, ok := buildDeployment(ctx, dep)
+			if !ok {
+				t.Fatal("buildDeployment() failed")
+			}
+			if tt.mode == deploymentModeStatic {
+				if err := finishBuildRunning(ctx, dep.UUID); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					startDevServer(ctx, dep.UUID, b.command, b.port, b.dir)
+				}()
+				waitForStatus(t, dep.UUID, StateRunning)
+				if err := stopMintlifyServer(dep.UUID); err != nil {
+					t.Fatal(err)
+				}
+				<-done
+			}
+
+			spans := exporter.GetSpans()
+			var root tracetest.SpanStub
+			for _, span := range spans {
+				if span.Name == "deployment" {
+					root = span
+				}
+			}
+			if !root.SpanContext.IsValid() || root.Parent.IsValid() {
+				t.Fatalf("no root deployment span in %d spans", len(spans))
+			}
+			if len(root.Links) != 1 || root.Links[0].SpanContext.TraceID().String() != "0af7651916cd43dd8448eb211c80319c" {
+				t.Errorf("root span links = %+v, want the creating request", root.Links)
+			}
+			var stages []string
+			for _, span := range spans {
+				if span.Name == "deployment" {
+					if span.SpanContext.SpanID() != root.SpanContext.SpanID() {
+						t.Errorf("more than one root span for the deployment")
+					}
+					continue
+				}
+				if span.Parent.SpanID() != root.SpanContext.SpanID() || span.SpanContext.TraceID() != root.SpanContext.TraceID() {
+					t.Errorf("span %s is not a child of the deployment span", span.Name)
+				}
+				stages = append(stages, span.Name)
+			}
+			var want []string
+			for _, stage := range tt.stages {
+				want = append(want, "deployment."+stage)
+			}
+			if !slices.Equal(stages, want) {
+				t.Errorf("stage spans = %v, want %v", stages, want)
+			}
+
+			entry, ok := log.FromContext(ctx).(*logrus.Entry)
+			if !ok || entry.Data["trace_id"] != root.SpanContext.TraceID().String() || entry.Data["uuid"] != tt.uuid {
+				t.Errorf("deployment log fields = %v, want the trace ID %s", entry.Data, root.SpanContext.TraceID())
+			}
+		})
+	}
+}
+
+// waitForStatus waits for a deployment to reach a state.
+func waitForStatus(t *testing.T, uuid string, want DeploymentState) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		dep, err := store.GetDeployment(uuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dep.Status == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deployment is %s, want %s", dep.Status, want)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}